@@ -0,0 +1,247 @@
+// Локальный провайдер OpenID Connect для проверки входа через OIDC без настоящего сервера идентификации.
+// Поддерживает discovery, authorization code с PKCE (S256), выдачу ID-токена RS256 и JWKS.
+// На странице входа можно указать любой email и список групп.
+//
+//	go run ./cmd/mock-oidc -addr :9000
+//	OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=catalog OIDC_CLIENT_SECRET=secret \
+//	OIDC_ROLE_MAPPING=catalog-admins=admin,catalog-editors=editor go run .
+package main
+
+import (
+	"cosmetics/models"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// выданный, но еще не обменянный код авторизации
+type authCode struct {
+	ClientID      string
+	RedirectURI   string
+	CodeChallenge string
+	Nonce         string
+	Email         string
+	Groups        []string
+	ExpiresAt     time.Time
+}
+
+type provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authCode
+}
+
+// страница входа провайдера
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Mock OIDC</title></head>
+<body style="font-family: sans-serif; max-width: 420px; margin: 60px auto;">
+    <h2>Тестовый провайдер входа</h2>
+    <form method="POST" action="/authorize">
+        {{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+        {{end}}
+        <p><label>Email<br><input type="email" name="email" value="admin@example.com" required style="width: 100%"></label></p>
+        <p><label>Группы через запятую<br><input type="text" name="groups" value="catalog-admins" style="width: 100%"></label></p>
+        <p><button type="submit">Войти</button></p>
+    </form>
+</body>
+</html>`))
+
+func main() {
+	addr := flag.String("addr", ":9000", "адрес HTTP-сервера провайдера")
+	issuer := flag.String("issuer", "http://localhost:9000", "идентификатор издателя (iss)")
+	clientID := flag.String("client-id", "catalog", "идентификатор клиента")
+	clientSecret := flag.String("client-secret", "secret", "секрет клиента")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Ошибка генерации ключа: %v", err)
+	}
+	p := &provider{
+		issuer:       strings.TrimRight(*issuer, "/"),
+		clientID:     *clientID,
+		clientSecret: *clientSecret,
+		key:          key,
+		codes:        make(map[string]authCode),
+	}
+
+	http.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	http.HandleFunc("/authorize", p.authorize)
+	http.HandleFunc("/token", p.token)
+	http.HandleFunc("/jwks", p.jwks)
+
+	log.Printf("Тестовый провайдер OIDC %s запущен на %s (client_id=%s)", p.issuer, *addr, p.clientID)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// документ discovery
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "profile", "email", "groups"},
+	})
+}
+
+// GET - страница входа, POST - выдача кода и возврат клиенту
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("client_id") != p.clientID {
+		http.Error(w, "неизвестный client_id", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("response_type") != "code" {
+		http.Error(w, "поддерживается только response_type=code", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("code_challenge") == "" || r.Form.Get("code_challenge_method") != "S256" {
+		http.Error(w, "требуется PKCE с code_challenge_method=S256", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "неверный redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		params := map[string]string{}
+		for _, name := range []string{"client_id", "response_type", "redirect_uri", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params[name] = r.Form.Get(name)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]interface{}{"Params": params})
+		return
+	}
+
+	code := randomString()
+	var groups []string
+	for _, group := range strings.Split(r.Form.Get("groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	p.mu.Lock()
+	p.codes[code] = authCode{
+		ClientID:      p.clientID,
+		RedirectURI:   redirectURI.String(),
+		CodeChallenge: r.Form.Get("code_challenge"),
+		Nonce:         r.Form.Get("nonce"),
+		Email:         strings.TrimSpace(r.Form.Get("email")),
+		Groups:        groups,
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", r.Form.Get("state"))
+	redirectURI.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// обмен кода на токены с проверкой клиента и PKCE
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.clientID || clientSecret != p.clientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	p.mu.Lock()
+	code, found := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code")) //код одноразовый
+	p.mu.Unlock()
+	if !found || time.Now().After(code.ExpiresAt) || code.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != code.CodeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                p.issuer,
+		"sub":                "mock|" + code.Email,
+		"aud":                code.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              code.Nonce,
+		"email":              code.Email,
+		"email_verified":     true,
+		"preferred_username": strings.Split(code.Email, "@")[0],
+		"groups":             code.Groups,
+	})
+	idToken.Header["kid"] = "mock"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+// открытый ключ подписи ID-токенов
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, models.JSONWebKeySet{Keys: []models.JSONWebKey{{
+		Kty: "RSA",
+		Kid: "mock",
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+// случайная строка для кодов и токенов
+func randomString() string {
+	buf := make([]byte, 24)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Режимы регистрации новых пользователей
+const (
+	RegistrationOpen       = "open"        // регистрация доступна всем
+	RegistrationInviteOnly = "invite-only" // регистрация только по коду приглашения
+	RegistrationClosed     = "closed"      // регистрация отключена
+)
+
+// Настройки сервера, считываемые из переменных окружения
+type Config struct {
+	Addr             string        // адрес HTTP-сервера
+	DBPath           string        // путь к файлу базы данных SQLite
+	RegistrationMode string        // режим регистрации (open / invite-only / closed)
+	InviteTTL        time.Duration // срок действия приглашения по умолчанию
+	AccessTokenTTL   time.Duration // срок действия JWT доступа
+	RefreshTokenTTL  time.Duration // срок действия токена обновления (сессии)
+	JWTKeysFile      string        // JSON-файл с набором ключей подписи JWT
+	JWTSecret        string        // секрет HS256, если файл ключей не задан
+	JWTKeyID         string        // kid для ключа из JWT_SECRET
+
+	LoginMaxFailures   int           // число неудачных попыток до блокировки учетной записи
+	LoginLockout       time.Duration // длительность блокировки учетной записи
+	LoginBackoffBase   time.Duration // начальная задержка между неудачными попытками, удваивается
+	LoginBackoffMax    time.Duration // максимальная задержка между попытками
+	LoginIPMaxFailures int           // неудачных попыток с одного IP до включения задержки
+	LoginIPWindow      time.Duration // окно подсчета неудачных попыток с одного IP
+	TrustProxy         bool          // брать IP клиента из X-Forwarded-For
+	ViewCountWindow    time.Duration // окно, в котором повторные просмотры продукта с одного IP не учитываются
+
+	PasswordMinLength      int           // минимальная длина пароля
+	PasswordRequireUpper   bool          // требовать заглавную букву
+	PasswordRequireLower   bool          // требовать строчную букву
+	PasswordRequireDigit   bool          // требовать цифру
+	PasswordRequireSpecial bool          // требовать специальный символ
+	PasswordRejectCommon   bool          // отклонять распространенные пароли
+	BcryptCost             int           // стоимость bcrypt для новых хешей
+	PasswordResetTTL       time.Duration // срок действия токена сброса пароля
+
+	TwoFactorRoles        []string      // роли, для которых двухфакторная аутентификация обязательна
+	TOTPIssuer            string        // имя сервиса в приложении-аутентификаторе
+	TwoFactorChallengeTTL time.Duration // время на ввод кода после проверки пароля
+
+	CSRFSecret     string // ключ подписи CSRF-токенов форм
+	CookieSecure   bool   // передавать cookie сессии только по HTTPS
+	CookieSameSite string // режим SameSite cookie сессии (lax / strict / none)
+
+	OIDCIssuer        string            // адрес провайдера OpenID Connect; пустой - вход через OIDC отключен
+	OIDCName          string            // название провайдера на кнопке входа
+	OIDCClientID      string            // идентификатор клиента у провайдера
+	OIDCClientSecret  string            // секрет клиента
+	OIDCRedirectURL   string            // адрес возврата после входа у провайдера
+	OIDCScopes        []string          // запрашиваемые области доступа
+	OIDCUsernameClaim string            // утверждение ID-токена, используемое как логин
+	OIDCGroupsClaim   string            // утверждение ID-токена со списком групп
+	OIDCRoleMapping   map[string]string // соответствие групп провайдера ролям
+	OIDCDefaultRole   string            // роль при отсутствии подходящих групп; пустая - вход запрещен
+}
+
+// Загрузка настроек с значениями по умолчанию
+func Load() *Config {
+	cfg := &Config{
+		Addr:             getEnv("ADDR", ":8080"),
+		DBPath:           getEnv("DB_PATH", "./cosmetics.db"),
+		RegistrationMode: getEnv("REGISTRATION_MODE", RegistrationInviteOnly),
+		InviteTTL:        getDuration("INVITE_TTL", 72*time.Hour),
+		AccessTokenTTL:   getDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:  getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		JWTKeysFile:      getEnv("JWT_KEYS_FILE", ""),
+		JWTSecret:        getEnv("JWT_SECRET", ""),
+		JWTKeyID:         getEnv("JWT_KEY_ID", "default"),
+
+		LoginMaxFailures:   getInt("LOGIN_MAX_FAILURES", 5),
+		LoginLockout:       getDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		LoginBackoffBase:   getDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:    getDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		LoginIPMaxFailures: getInt("LOGIN_IP_MAX_FAILURES", 20),
+		LoginIPWindow:      getDuration("LOGIN_IP_WINDOW", 15*time.Minute),
+		TrustProxy:         getBool("TRUST_PROXY", false),
+		ViewCountWindow:    getDuration("VIEW_COUNT_WINDOW", 30*time.Minute),
+
+		PasswordMinLength:      getInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordRequireUpper:   getBool("PASSWORD_REQUIRE_UPPER", false),
+		PasswordRequireLower:   getBool("PASSWORD_REQUIRE_LOWER", false),
+		PasswordRequireDigit:   getBool("PASSWORD_REQUIRE_DIGIT", true),
+		PasswordRequireSpecial: getBool("PASSWORD_REQUIRE_SPECIAL", false),
+		PasswordRejectCommon:   getBool("PASSWORD_REJECT_COMMON", true),
+		BcryptCost:             getInt("BCRYPT_COST", 10),
+		PasswordResetTTL:       getDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		TwoFactorRoles:        getList("TWO_FACTOR_ROLES"),
+		TOTPIssuer:            getEnv("TOTP_ISSUER", "Cosmetics"),
+		TwoFactorChallengeTTL: getDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
+
+		CSRFSecret:     getEnv("CSRF_SECRET", ""),
+		CookieSecure:   getBool("COOKIE_SECURE", false),
+		CookieSameSite: getEnv("COOKIE_SAMESITE", "lax"),
+
+		OIDCIssuer:        getEnv("OIDC_ISSUER", ""),
+		OIDCName:          getEnv("OIDC_NAME", "корпоративную учетную запись"),
+		OIDCClientID:      getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:  getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:   getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/login/oidc/callback"),
+		OIDCScopes:        getList("OIDC_SCOPES"),
+		OIDCUsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "email"),
+		OIDCGroupsClaim:   getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCRoleMapping:   getMap("OIDC_ROLE_MAPPING"),
+		OIDCDefaultRole:   getEnv("OIDC_DEFAULT_ROLE", ""),
+	}
+	if len(cfg.OIDCScopes) == 0 {
+		cfg.OIDCScopes = []string{"openid", "profile", "email", "groups"}
+	}
+
+	//допустимый диапазон стоимости bcrypt: 4..31
+	if cfg.BcryptCost < 4 || cfg.BcryptCost > 31 {
+		log.Printf("Неверное значение BCRYPT_COST=%d, используется 10", cfg.BcryptCost)
+		cfg.BcryptCost = 10
+	}
+
+	//SameSite=None браузеры принимают только вместе с Secure
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "lax", "strict":
+	case "none":
+		if !cfg.CookieSecure {
+			log.Printf("COOKIE_SAMESITE=none требует COOKIE_SECURE=true, используется lax")
+			cfg.CookieSameSite = "lax"
+		}
+	default:
+		log.Printf("Неизвестный режим COOKIE_SAMESITE=%q, используется lax", cfg.CookieSameSite)
+		cfg.CookieSameSite = "lax"
+	}
+
+	switch cfg.RegistrationMode {
+	case RegistrationOpen, RegistrationInviteOnly, RegistrationClosed:
+	default:
+		log.Printf("Неизвестный режим регистрации %q, используется %q", cfg.RegistrationMode, RegistrationInviteOnly)
+		cfg.RegistrationMode = RegistrationInviteOnly
+	}
+	return cfg
+}
+
+// получение строковой переменной окружения
+func getEnv(key, def string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return def
+}
+
+// получение длительности в формате time.ParseDuration (например, 15m, 72h)
+func getDuration(key string, def time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Неверное значение %s=%q, используется %s", key, value, def)
+		return def
+	}
+	return d
+}
+
+// получение целого числа
+func getInt(key string, def int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Неверное значение %s=%q, используется %d", key, value, def)
+		return def
+	}
+	return n
+}
+
+// получение логического значения (1, true, yes)
+func getBool(key string, def bool) bool {
+	value := getEnv(key, "")
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Неверное значение %s=%q, используется %t", key, value, def)
+		return def
+	}
+	return b
+}
+
+// получение списка значений через запятую (например, admin,editor)
+func getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// получение соответствия вида ключ=значение через запятую (например, admins=admin,staff=editor)
+func getMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, item := range getList(key) {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			log.Printf("Неверный элемент %s=%q, ожидается ключ=значение", key, item)
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
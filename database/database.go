@@ -1,18 +1,47 @@
 package database
 
 import (
+	"cosmetics/models"
 	"database/sql"
+	"fmt"
 	"log"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 var DB *sql.DB
 
+// имя драйвера SQLite с функциями каталога
+const driverName = "sqlite3_cosmetics"
+
+// драйвер go-sqlite3, регистрирующий в каждом соединении функцию search_key(text) -
+// поисковый ключ models.SearchKey для поиска без учета регистра, ё/е и с транслитерацией
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("search_key", searchKey, true)
+		},
+	})
+}
+
+// search_key для значений SQLite: NULL остается NULL, числа приводятся к тексту
+func searchKey(value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return models.SearchKey(v)
+	case []byte:
+		return models.SearchKey(string(v))
+	default:
+		return models.SearchKey(fmt.Sprint(v))
+	}
+}
+
 // инициализация и проверка подключения к базе данных
-func InitDB() error {
+func InitDB(path string) error {
 	var err error
-	DB, err = sql.Open("sqlite3", "./cosmetics.db")
+	DB, err = sql.Open(driverName, path)
 	if err != nil {
 		return err
 	}
@@ -23,5 +52,10 @@ func InitDB() error {
 	}
 
 	log.Println("Подключение к базе косметических продуктов успешно :)")
+
+	//Приведение схемы к актуальной версии
+	if err := migrate(DB); err != nil {
+		return err
+	}
 	return nil
 }
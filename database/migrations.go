@@ -0,0 +1,236 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Миграции схемы применяются по порядку, номер последней примененной
+// миграции хранится в PRAGMA user_version
+var migrations = []string{
+	// 1: роли пользователей; существующие учетные записи сохраняют права администратора
+	`ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'viewer';
+	 UPDATE users SET role = 'admin';`,
+	// 2: одноразовые приглашения для регистрации
+	`CREATE TABLE invites (
+		id INTEGER PRIMARY KEY NOT NULL,
+		code_hash TEXT UNIQUE NOT NULL,
+		role TEXT NOT NULL DEFAULT 'viewer',
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME,
+		used_by INTEGER REFERENCES users (id) ON DELETE SET NULL
+	);`,
+	// 3: сессии с ротируемыми токенами обновления и список отозванных JWT
+	`CREATE TABLE sessions (
+		id TEXT PRIMARY KEY NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT ''
+	);
+	CREATE TABLE refresh_tokens (
+		id INTEGER PRIMARY KEY NOT NULL,
+		session_id TEXT NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL,
+		used_at DATETIME
+	);
+	CREATE TABLE revoked_tokens (
+		jti TEXT PRIMARY KEY NOT NULL,
+		user_id INTEGER,
+		revoked_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_sessions_user ON sessions (user_id);
+	CREATE INDEX idx_refresh_tokens_session ON refresh_tokens (session_id);`,
+	// 4: журнал попыток входа и блокировка учетных записей
+	`CREATE TABLE login_attempts (
+		id INTEGER PRIMARY KEY NOT NULL,
+		username TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		success INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_login_attempts_username ON login_attempts (username, created_at);
+	CREATE INDEX idx_login_attempts_ip ON login_attempts (ip, created_at);
+	ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN locked_until DATETIME;`,
+	// 5: одноразовые токены сброса пароля
+	`CREATE TABLE password_resets (
+		id INTEGER PRIMARY KEY NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		used_at DATETIME
+	);`,
+	// 6: отключение учетных записей
+	`ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;`,
+	// 7: двухфакторная аутентификация (TOTP), коды восстановления и незавершенные входы
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT;
+	ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+	CREATE TABLE recovery_codes (
+		id INTEGER PRIMARY KEY NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME
+	);
+	CREATE INDEX idx_recovery_codes_user ON recovery_codes (user_id);
+	CREATE TABLE mfa_challenges (
+		id INTEGER PRIMARY KEY NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		token_hash TEXT UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		used_at DATETIME
+	);`,
+	// 8: внешние учетные записи (OpenID Connect), привязанные к пользователям
+	`CREATE TABLE user_identities (
+		id INTEGER PRIMARY KEY NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_login_at DATETIME,
+		UNIQUE (issuer, subject)
+	);
+	CREATE INDEX idx_user_identities_user ON user_identities (user_id);`,
+	// 9: API-ключи машинных клиентов и учет их использования по дням
+	`CREATE TABLE api_keys (
+		id INTEGER PRIMARY KEY NOT NULL,
+		name TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL,
+		daily_quota INTEGER NOT NULL DEFAULT 0,
+		created_by TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME,
+		revoked_at DATETIME,
+		last_used_at DATETIME
+	);
+	CREATE TABLE api_key_usage (
+		key_id INTEGER NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+		day TEXT NOT NULL,
+		count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (key_id, day)
+	);`,
+	// 10: журнал аудита изменений каталога и пользователей
+	`CREATE TABLE audit_log (
+		id INTEGER PRIMARY KEY NOT NULL,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		entity_type TEXT NOT NULL,
+		entity_id INTEGER NOT NULL,
+		before_json TEXT,
+		after_json TEXT,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX idx_audit_log_entity ON audit_log (entity_type, entity_id);
+	CREATE INDEX idx_audit_log_created ON audit_log (created_at);`,
+	// 11: связь продукта с ингредиентом не повторяется; дубликаты удаляются
+	`DELETE FROM product_structure WHERE rowid NOT IN (
+		SELECT MIN(rowid) FROM product_structure GROUP BY product_id, structure_id
+	);
+	CREATE UNIQUE INDEX idx_product_structure_pair ON product_structure (product_id, structure_id);
+	CREATE INDEX idx_product_structure_structure ON product_structure (structure_id);`,
+	// 12: число просмотров продуктов - популярность для подсказок поиска
+	`CREATE TABLE product_views (
+		product_id INTEGER PRIMARY KEY NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+		views INTEGER NOT NULL DEFAULT 0
+	);`,
+	// 13: семейства ингредиентов (дерево) и принадлежность ингредиентов семействам
+	`CREATE TABLE ingredient_family (
+		family_id INTEGER PRIMARY KEY NOT NULL,
+		family_name TEXT NOT NULL,
+		parent_id INTEGER REFERENCES ingredient_family (family_id)
+	);
+	CREATE UNIQUE INDEX idx_ingredient_family_name ON ingredient_family (family_name COLLATE NOCASE);
+	CREATE INDEX idx_ingredient_family_parent ON ingredient_family (parent_id);
+	CREATE TABLE structure_family (
+		structure_id INTEGER NOT NULL REFERENCES structure (structure_id) ON DELETE CASCADE,
+		family_id INTEGER NOT NULL REFERENCES ingredient_family (family_id) ON DELETE CASCADE,
+		PRIMARY KEY (structure_id, family_id)
+	);
+	CREATE INDEX idx_structure_family_family ON structure_family (family_id);`,
+	// 14: каноническое название INCI, номера CAS и EC и синонимы ингредиентов на разных языках
+	`ALTER TABLE structure ADD COLUMN inci_name TEXT;
+	ALTER TABLE structure ADD COLUMN cas_number TEXT;
+	ALTER TABLE structure ADD COLUMN ec_number TEXT;
+	CREATE TABLE structure_synonym (
+		synonym_id INTEGER PRIMARY KEY NOT NULL,
+		structure_id INTEGER NOT NULL REFERENCES structure (structure_id) ON DELETE CASCADE,
+		synonym TEXT NOT NULL,
+		language TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX idx_structure_synonym_structure ON structure_synonym (structure_id);`,
+	// 15: ингредиенты состава из раздела «может содержать» (+/-)
+	`ALTER TABLE product_structure ADD COLUMN may_contain INTEGER NOT NULL DEFAULT 0;`,
+	// 16: место ингредиента на этикетке (с 1) и концентрация в процентах; below_one_percent - «менее 1%»
+	// без точного значения; места существующих составов - по порядку записи
+	`ALTER TABLE product_structure ADD COLUMN position INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE product_structure ADD COLUMN concentration REAL;
+	ALTER TABLE product_structure ADD COLUMN below_one_percent INTEGER NOT NULL DEFAULT 0;
+	UPDATE product_structure SET position = (SELECT COUNT(*) FROM product_structure p
+		WHERE p.product_id = product_structure.product_id AND p.rowid <= product_structure.rowid);
+	CREATE INDEX idx_product_structure_position ON product_structure (product_id, position);`,
+	// 17: пользователь создан при первом входе через провайдера - только его роль следует группам провайдера;
+	// для существующих привязок это неизвестно, и роль им не синхронизируется
+	`ALTER TABLE user_identities ADD COLUMN created_user INTEGER NOT NULL DEFAULT 0;`,
+	// 18: номер версии текстов каталога (названия, описания, производители, ингредиенты); увеличивается
+	// триггерами при каждом изменении, по нему сбрасывается кэш словаря исправления опечаток
+	`CREATE TABLE catalog_version (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		version INTEGER NOT NULL
+	);
+	INSERT INTO catalog_version (id, version) VALUES (1, 0);
+	CREATE TRIGGER catalog_version_product_insert AFTER INSERT ON products BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_product_update AFTER UPDATE OF product_title, product_description, application ON products
+		BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_product_delete AFTER DELETE ON products BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_manufacturer_insert AFTER INSERT ON manufacturer BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_manufacturer_update AFTER UPDATE OF manufacturer_title ON manufacturer
+		BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_manufacturer_delete AFTER DELETE ON manufacturer BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_structure_insert AFTER INSERT ON structure BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_structure_update AFTER UPDATE OF structure_name ON structure
+		BEGIN UPDATE catalog_version SET version = version + 1; END;
+	CREATE TRIGGER catalog_version_structure_delete AFTER DELETE ON structure BEGIN UPDATE catalog_version SET version = version + 1; END;`,
+}
+
+// применение недостающих миграций к базе данных
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("ошибка чтения версии схемы: %w", err)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ошибка миграции %d: %w", i+1, err)
+		}
+		//PRAGMA не поддерживает параметры, номер подставляется в строку
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("ошибка обновления версии схемы: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("Применена миграция схемы БД %d", i+1)
+	}
+	return nil
+}
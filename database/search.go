@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// Строки полнотекстового индекса для продуктов, выбранных условием where:
+// название, описание, применение, противопоказания, производитель, названия ингредиентов
+// (вместе с названиями INCI и синонимами) и поисковые ключи названий продукта и производителя (search_key: регистр, ё/е, транслитерация)
+const searchRowSQL = `INSERT INTO products_fts (rowid, title, description, application, contraindications, manufacturer, structures, title_key, manufacturer_key)
+	SELECT p.product_id, p.product_title, p.product_description, p.application, COALESCE(p.contraindications, ''),
+		COALESCE(m.manufacturer_title, ''),
+		COALESCE((SELECT group_concat(s.structure_name || COALESCE(' ' || s.inci_name, '') ||
+				COALESCE((SELECT ' ' || group_concat(ss.synonym, ' ') FROM structure_synonym ss WHERE ss.structure_id = s.structure_id), ''), ' ')
+			FROM product_structure ps JOIN structure s ON s.structure_id = ps.structure_id WHERE ps.product_id = p.product_id), ''),
+		search_key(p.product_title), search_key(COALESCE(m.manufacturer_title, ''))
+	FROM products p LEFT JOIN manufacturer m ON m.manufacturer_id = p.manufacturer_id
+	WHERE %s`
+
+// Триггеры синхронизации индекса: при каждом изменении продукта, производителя, ингредиента,
+// его синонимов или состава строки затронутых продуктов удаляются и собираются заново
+var searchTriggers = []struct {
+	name, event, products string
+}{
+	{"products_fts_insert", "AFTER INSERT ON products", "NEW.product_id"},
+	{"products_fts_update", "AFTER UPDATE ON products", "NEW.product_id"},
+	{"products_fts_delete", "AFTER DELETE ON products", "OLD.product_id"},
+	{"products_fts_manufacturer", "AFTER UPDATE OF manufacturer_title ON manufacturer",
+		"SELECT product_id FROM products WHERE manufacturer_id = NEW.manufacturer_id"},
+	{"products_fts_structure", "AFTER UPDATE OF structure_name, inci_name ON structure",
+		"SELECT product_id FROM product_structure WHERE structure_id = NEW.structure_id"},
+	{"products_fts_synonym_insert", "AFTER INSERT ON structure_synonym",
+		"SELECT product_id FROM product_structure WHERE structure_id = NEW.structure_id"},
+	{"products_fts_synonym_update", "AFTER UPDATE ON structure_synonym",
+		"SELECT product_id FROM product_structure WHERE structure_id IN (OLD.structure_id, NEW.structure_id)"},
+	{"products_fts_synonym_delete", "AFTER DELETE ON structure_synonym",
+		"SELECT product_id FROM product_structure WHERE structure_id = OLD.structure_id"},
+	{"products_fts_link_insert", "AFTER INSERT ON product_structure", "NEW.product_id"},
+	{"products_fts_link_delete", "AFTER DELETE ON product_structure", "OLD.product_id"},
+}
+
+// Поддержка FTS5 в собранной библиотеке SQLite (go-sqlite3 с тегом сборки sqlite_fts5)
+func fullTextAvailable(db *sql.DB) bool {
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return false
+	}
+	return enabled
+}
+
+// Подготовка полнотекстового поиска по продуктам
+// С FTS5 заново создает таблицу products_fts (ее столбцы могут меняться между версиями), триггеры
+// и индекс (каталог небольшой);
+// без FTS5 удаляет триггеры, оставшиеся от запуска с FTS5, иначе любая запись продукта завершится ошибкой
+// Возвращает true, если полнотекстовый поиск доступен
+func SetupSearch(db *sql.DB) (bool, error) {
+	available := fullTextAvailable(db)
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	for _, trigger := range searchTriggers {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + trigger.name); err != nil {
+			return false, err
+		}
+	}
+	if !available {
+		log.Println("SQLite собран без FTS5 (тег сборки sqlite_fts5): поиск продуктов выполняется через LIKE")
+		return false, tx.Commit()
+	}
+
+	statements := []string{
+		"DROP TABLE IF EXISTS products_fts",
+		`CREATE VIRTUAL TABLE products_fts USING fts5(
+			title, description, application, contraindications, manufacturer, structures, title_key, manufacturer_key,
+			tokenize = 'unicode61 remove_diacritics 2', prefix = '2 3')`,
+		fmt.Sprintf(searchRowSQL, "1"),
+	}
+	for _, trigger := range searchTriggers {
+		statements = append(statements, fmt.Sprintf(
+			"CREATE TRIGGER %s %s BEGIN DELETE FROM products_fts WHERE rowid IN (%s); %s; END",
+			trigger.name, trigger.event, trigger.products, fmt.Sprintf(searchRowSQL, "p.product_id IN ("+trigger.products+")")))
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	log.Println("Полнотекстовый индекс продуктов (FTS5) построен")
+	return true, nil
+}
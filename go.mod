@@ -6,9 +6,16 @@ require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/gorilla/mux v1.8.1
 	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/pquerna/otp v1.5.0
 )
 
 require (
-	github.com/golang-jwt/jwt v3.2.2+incompatible
+	github.com/coreos/go-oidc/v3 v3.17.0
 	golang.org/x/crypto v0.43.0
+	golang.org/x/oauth2 v0.28.0
+)
+
+require (
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc // indirect
+	github.com/go-jose/go-jose/v4 v4.1.3 // indirect
 )
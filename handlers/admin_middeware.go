@@ -2,37 +2,242 @@ package handlers
 
 import (
 	"context"
+	"cosmetics/config"
+	"cosmetics/models"
+	"cosmetics/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
+)
+
+// Зависимости проверки авторизации: пользователи, сессии, API-ключи и настройки токенов
+type Authenticator struct {
+	Users    *repository.UserRepository
+	Sessions *repository.SessionRepository
+	APIKeys  *repository.APIKeyRepository
+	Config   *config.Config
+}
 
-	jwt "github.com/golang-jwt/jwt/v5"
+// конструктор для создания экземпляра с внедренными репозиториями
+func NewAuthenticator(users *repository.UserRepository, sessions *repository.SessionRepository, apiKeys *repository.APIKeyRepository, cfg *config.Config) *Authenticator {
+	return &Authenticator{Users: users, Sessions: sessions, APIKeys: apiKeys, Config: cfg}
+}
+
+// Способ авторизации запроса (значение "auth_source" контекста): от него зависит проверка CSRF
+const (
+	authSourceBearer = "bearer" // заголовок Authorization: Bearer
+	authSourceCookie = "cookie" // cookie браузерной сессии
+	authSourceAPIKey = "apikey" // заголовок X-API-Key
 )
 
-//Выполняет поиск JWT в cookie запроса
-//Если токен найден, идет проверка подлинности
-//Если токена нет или он недействителен, редиект на login
+//Запросы машинных клиентов с заголовком X-API-Key проверяются по API-ключу (см. apiKeyAuth)
+//Иначе выполняет поиск JWT в заголовке Authorization (Bearer) или в cookie запроса
+//Если токен найден, идет проверка подлинности и отсутствия в списке отозванных
+//Если токен cookie истек, сессия браузера прозрачно продлевается по cookie refresh_token
+//Если токена нет или он недействителен: API-запросы получают JSON 401, страницы - редирект на login
 //Если токен действителен, идет передача следующему обработчику
 
-func AuthMiddleware(next http.Handler) http.Handler {
+func (a *Authenticator) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//Авторизация машинного клиента по API-ключу
+		if raw := r.Header.Get("X-API-Key"); raw != "" {
+			a.apiKeyAuth(w, r, strings.TrimSpace(raw), next)
+			return
+		}
 		//Извлечение и проверка токена на существование
-		cookie, err := r.Cookie("token")
+		tokenString, source := extractToken(r)
+		var claims *Claims
+		if tokenString != "" {
+			claims, _ = parseToken(tokenString)
+		}
+		//Продление браузерной сессии при отсутствии действительного токена
+		if claims == nil && r.Header.Get("Authorization") == "" {
+			claims, source = a.refreshFromCookie(w, r), authSourceCookie
+		}
+		if claims == nil {
+			denyUnauthenticated(w, r, "Требуется авторизация")
+			return
+		}
+		//Проверка списка отзыва
+		revoked, err := a.Sessions.IsRevoked(claims.ID, claims.SessionID)
 		if err != nil {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			log.Printf("Ошибка проверки отзыва токена: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 			return
 		}
-		//Создание экземпляра структуры для хранения токена пользователя и извлечение jwt-ключа
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		//Проверка на валидность ключа
-		if err != nil || !token.Valid {
-			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		if revoked {
+			denyUnauthenticated(w, r, "Токен отозван")
+			return
+		}
+		//Проверка, что пользователь существует и не отключен; роль берется из БД,
+		//поэтому ее изменение действует сразу, без перевыпуска токена
+		user, err := a.Users.GetUserByID(claims.UserID)
+		if err != nil {
+			log.Printf("Ошибка получения пользователя: %v", err)
+			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+			return
+		}
+		if user == nil || user.Disabled {
+			denyUnauthenticated(w, r, "Учетная запись отключена или удалена")
 			return
 		}
+		claims.Role = user.Role
 		//Передача данных
-		ctx := context.WithValue(r.Context(), "username", claims.Username)
+		ctx := context.WithValue(r.Context(), "username", user.UserName)
+		ctx = context.WithValue(ctx, "role", user.Role)
+		ctx = context.WithValue(ctx, "claims", claims)
+		ctx = context.WithValue(ctx, "auth_source", source)
 		//Вызов следующего обработчика
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Авторизация по API-ключу: ключ должен быть действующим, а число запросов за сутки (UTC)
+// не должно превышать квоту. В контекст помещаются имя "apikey:<название>" и сам ключ;
+// роли и клеймов сессии у ключа нет, права определяются его областями доступа
+func (a *Authenticator) apiKeyAuth(w http.ResponseWriter, r *http.Request, raw string, next http.Handler) {
+	key, err := a.APIKeys.Authenticate(raw)
+	if errors.Is(err, repository.ErrAPIKeyInvalid) {
+		handleUnauthorized(w, "Недействительный API-ключ")
+		return
+	}
+	if err != nil {
+		log.Printf("Ошибка проверки API-ключа: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if key.DailyQuota > 0 && key.UsedToday > key.DailyQuota {
+		now := time.Now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		setRetryAfter(w, midnight.Sub(now))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(models.ErrorResponse{
+			Error:   http.StatusText(http.StatusTooManyRequests),
+			Message: fmt.Sprintf("Превышена суточная квота API-ключа (%d)", key.DailyQuota),
+		})
+		return
+	}
+	ctx := context.WithValue(r.Context(), "username", "apikey:"+key.Name)
+	ctx = context.WithValue(ctx, "apikey", key)
+	ctx = context.WithValue(ctx, "auth_source", authSourceAPIKey)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Обновление сессии по cookie refresh_token; при успехе устанавливает новые cookie
+func (a *Authenticator) refreshFromCookie(w http.ResponseWriter, r *http.Request) *Claims {
+	cookie, err := r.Cookie("refresh_token")
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	pair, _, err := refreshSession(a.Users, a.Sessions, a.Config, cookie.Value)
+	if err != nil {
+		if !isRefreshRejected(err) {
+			log.Printf("Ошибка обновления сессии: %v", err)
+		}
+		clearSessionCookies(w)
+		return nil
+	}
+	setSessionCookies(w, pair, a.Config)
+	claims, err := parseToken(pair.AccessToken)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// Извлечение JWT и способа авторизации: сначала заголовок "Authorization: Bearer <token>", затем cookie "token"
+func extractToken(r *http.Request) (string, string) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, value, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(value), authSourceBearer
+		}
+	}
+	if cookie, err := r.Cookie("token"); err == nil {
+		return cookie.Value, authSourceCookie
+	}
+	return "", ""
+}
+
+// Определение API-запроса: клиент ожидает JSON или обращается к /api/
+// Браузерные формы, отправляемые на /api/ (Accept: text/html), считаются страницами
+func isAPIRequest(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return true
+	}
+	if strings.Contains(accept, "text/html") {
+		return false
+	}
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+// Отказ в доступе неавторизованному клиенту: JSON 401 для API, редирект на login для страниц
+func denyUnauthenticated(w http.ResponseWriter, r *http.Request, message string) {
+	if isAPIRequest(r) {
+		handleUnauthorized(w, message)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// Извлечение роли пользователя, помещенной в контекст AuthMiddleware
+func roleFromContext(r *http.Request) models.Role {
+	role, _ := r.Context().Value("role").(models.Role)
+	return role
+}
+
+// Извлечение клеймов токена, помещенных в контекст AuthMiddleware
+func claimsFromContext(r *http.Request) *Claims {
+	claims, _ := r.Context().Value("claims").(*Claims)
+	return claims
+}
+
+// Способ авторизации запроса, помещенный в контекст AuthMiddleware
+func authSourceFromContext(r *http.Request) string {
+	source, _ := r.Context().Value("auth_source").(string)
+	return source
+}
+
+// Извлечение API-ключа, помещенного в контекст AuthMiddleware; nil для пользовательских сессий
+func apiKeyFromContext(r *http.Request) *models.APIKey {
+	key, _ := r.Context().Value("apikey").(*models.APIKey)
+	return key
+}
+
+// Проверка права текущего клиента: по областям API-ключа или по роли пользователя
+func can(r *http.Request, perm models.Permission) bool {
+	if key := apiKeyFromContext(r); key != nil {
+		return key.Can(perm)
+	}
+	return roleFromContext(r).Can(perm)
+}
+
+// Проверка права доступа для отдельного маршрута
+// Используется после AuthMiddleware, при отсутствии права отвечает 403
+func RequirePermission(perm models.Permission, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !can(r, perm) {
+			handleForbidden(w, "Недостаточно прав для выполнения операции")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Ограничение маршрута пользовательскими сессиями: операции со своей учетной записью
+// (выход, смена пароля, 2FA) недоступны по API-ключу
+func RequireSession(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claimsFromContext(r) == nil {
+			handleForbidden(w, "Операция доступна только пользователю, вошедшему в систему")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"cosmetics/repository"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// максимальный период отчета об использовании ключа, суток
+const maxUsageDays = 366
+
+type APIKeyHandler struct {
+	Repo *repository.APIKeyRepository
+}
+
+// конструктор экземпляра обработчика
+func NewAPIKeyHandler(repo *repository.APIKeyRepository) *APIKeyHandler {
+	return &APIKeyHandler{Repo: repo}
+}
+
+// тело запроса создания API-ключа
+type createAPIKeyRequest struct {
+	Name           string              `json:"name"`
+	Scopes         []models.Permission `json:"scopes"`
+	ExpiresInHours int                 `json:"expires_in_hours"` // 0 - бессрочный ключ
+	DailyQuota     int                 `json:"daily_quota"`      // 0 - без ограничения
+}
+
+// отчет об использовании ключа
+type apiKeyUsageResponse struct {
+	Key   *models.APIKey       `json:"key"`
+	Days  int                  `json:"days"`
+	Total int                  `json:"total"`
+	Usage []models.APIKeyUsage `json:"usage"`
+}
+
+// обработчик POST: создание ключа, открытое значение возвращается один раз
+func (h *APIKeyHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Не указано название ключа", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "Не указаны области доступа ключа", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !scope.Valid() {
+			http.Error(w, "Неизвестная область доступа: "+string(scope), http.StatusBadRequest)
+			return
+		}
+		//ключ не может получить права, которых нет у создающего его клиента
+		if !can(r, scope) {
+			handleForbidden(w, "Нельзя выдать ключу область доступа, которой нет у вас: "+string(scope))
+			return
+		}
+	}
+	if req.ExpiresInHours < 0 || req.DailyQuota < 0 {
+		http.Error(w, "Срок действия и квота не могут быть отрицательными", http.StatusBadRequest)
+		return
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	key := models.APIKey{
+		Name:       req.Name,
+		Scopes:     req.Scopes,
+		DailyQuota: req.DailyQuota,
+		CreatedBy:  username,
+	}
+	if req.ExpiresInHours > 0 {
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := h.Repo.Create(&key); err != nil {
+		log.Printf("Ошибка создания API-ключа: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Пользователь '%s' создал API-ключ '%s' (%s)", username, key.Name, key.Prefix)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.Response{Message: "API-ключ создан успешно, сохраните его: повторно он не показывается", Data: key})
+}
+
+// обработчик GETAll
+func (h *APIKeyHandler) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.Repo.GetAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "API-ключи получены успешно", Data: keys})
+}
+
+// обработчик DELETE: отзыв ключа, запись остается для истории использования
+func (h *APIKeyHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Repo.Revoke(key.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	log.Printf("Пользователь '%s' отозвал API-ключ '%s' (%s)", username, key.Name, key.Prefix)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "API-ключ отозван успешно"})
+}
+
+// обработчик GET: число запросов по ключу по суткам (UTC) за последние days суток (по умолчанию 30)
+func (h *APIKeyHandler) GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxUsageDays {
+			http.Error(w, "Параметр days должен быть числом от 1 до 366", http.StatusBadRequest)
+			return
+		}
+		days = parsed
+	}
+	usage, err := h.Repo.Usage(key.ID, days)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := apiKeyUsageResponse{Key: key, Days: days, Usage: usage}
+	for _, u := range usage {
+		report.Total += u.Count
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Использование API-ключа получено успешно", Data: report})
+}
+
+// ключ из параметра id маршрута; при ошибке ответ уже отправлен
+func (h *APIKeyHandler) keyFromPath(w http.ResponseWriter, r *http.Request) (*models.APIKey, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор API-ключа", http.StatusBadRequest)
+		return nil, false
+	}
+	key, err := h.Repo.GetByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if key == nil {
+		http.Error(w, "API-ключ не найден", http.StatusNotFound)
+		return nil, false
+	}
+	return key, true
+}
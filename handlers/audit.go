@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"cosmetics/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// формат даты в фильтрах журнала аудита
+const auditDateLayout = "2006-01-02"
+
+type AuditHandler struct {
+	Repo *repository.AuditRepository
+}
+
+// конструктор экземпляра обработчика
+func NewAuditHandler(repo *repository.AuditRepository) *AuditHandler {
+	return &AuditHandler{Repo: repo}
+}
+
+// Запись изменения в журнал аудита от имени текущего клиента (username из контекста AuthMiddleware)
+// before и after сериализуются в JSON; nil означает отсутствие состояния (создание или удаление)
+// Ошибка записи только логируется: сама операция к этому моменту уже выполнена
+func recordAudit(repo *repository.AuditRepository, r *http.Request, action models.AuditAction, entityType string, entityID int, before, after interface{}) {
+	actor, _ := r.Context().Value("username").(string)
+	entry := models.AuditEntry{Actor: actor, Action: action, EntityType: entityType, EntityID: entityID}
+	var err error
+	if entry.Before, err = auditState(before); err == nil {
+		entry.After, err = auditState(after)
+	}
+	if err == nil {
+		err = repo.Record(&entry)
+	}
+	if err != nil {
+		log.Printf("Ошибка записи в журнал аудита (%s %s ID %d): %v", action, entityType, entityID, err)
+	}
+}
+
+// JSON состояния сущности; nil (в том числе nil-указатель) - состояния нет
+func auditState(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil || string(data) == "null" {
+		return nil, err
+	}
+	return data, nil
+}
+
+// обработчик GET: журнал аудита с фильтрами actor, action, entity_type, entity_id,
+// from и to (дата 2006-01-02 или RFC 3339; to для даты включает весь день) и limit
+func (h *AuditHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := h.Repo.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Журнал аудита получен успешно", Data: entries})
+}
+
+// фильтр журнала из параметров запроса
+func parseAuditFilter(r *http.Request) (repository.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := repository.AuditFilter{
+		Actor:      strings.TrimSpace(query.Get("actor")),
+		Action:     models.AuditAction(strings.TrimSpace(query.Get("action"))),
+		EntityType: strings.TrimSpace(query.Get("entity_type")),
+		Limit:      100,
+	}
+	if value := query.Get("entity_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("Неверный параметр entity_id")
+		}
+		filter.EntityID = id
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > 1000 {
+			return filter, errors.New("Параметр limit должен быть числом от 1 до 1000")
+		}
+		filter.Limit = limit
+	}
+	var err error
+	if filter.From, err = parseAuditTime(query.Get("from"), false); err != nil {
+		return filter, fmt.Errorf("Неверный параметр from: %w", err)
+	}
+	if filter.To, err = parseAuditTime(query.Get("to"), true); err != nil {
+		return filter, fmt.Errorf("Неверный параметр to: %w", err)
+	}
+	return filter, nil
+}
+
+// разбор времени фильтра; дата без времени для верхней границы включает весь день
+func parseAuditTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(auditDateLayout, value)
+	if err != nil {
+		return time.Time{}, errors.New("ожидается дата 2006-01-02 или время RFC 3339")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -4,13 +4,31 @@ import (
 	"html/template"
 	"log"
 	"net/http"
-	"time"
+	"strings"
 )
 
 //Обработчики для HTTP запросов для регистрации и входа
 
+// данные для шаблона страницы входа
+type LoginPageData struct {
+	MFAToken      string          // токен второго шага входа
+	Enrollment    *totpEnrollment // данные подключения 2FA, если она обязательна и еще не подключена
+	QRCode        template.URL    // QR-код подключения (data URI)
+	RecoveryCodes []string        // коды восстановления, показываемые один раз после подключения
+	OIDCName      string          // название провайдера OpenID Connect; пустое, если вход через него отключен
+}
+
 // Отрисовка страницы входа
 func (h *UserHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	data := LoginPageData{}
+	if h.Config.OIDCIssuer != "" {
+		data.OIDCName = h.Config.OIDCName
+	}
+	renderLogin(w, data)
+}
+
+// Выполнение шаблона страницы входа (форма пароля, ввод кода 2FA или коды восстановления)
+func renderLogin(w http.ResponseWriter, data LoginPageData) {
 	//Загрузка и парсинг шаблона для логина
 	tmpl, err := template.ParseFiles("views/login.html")
 	//Обработка ошибок
@@ -21,7 +39,13 @@ func (h *UserHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
 	//Передача заголовка браузеру
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	//Выполняет шаблон
-	tmpl.ExecuteTemplate(w, "login", nil)
+	tmpl.ExecuteTemplate(w, "login", data)
+}
+
+// данные для шаблона страницы регистрации
+type RegisterPageData struct {
+	Mode       string // режим регистрации из настроек
+	InviteCode string // код приглашения из ссылки ?invite=
 }
 
 // Отрисовка страницы регистрации
@@ -36,7 +60,33 @@ func (h *UserHandler) RegisterPage(w http.ResponseWriter, r *http.Request) {
 	//Передача заголовка браузеру
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	//Выполняет шаблон
-	tmpl.ExecuteTemplate(w, "register", nil)
+	tmpl.ExecuteTemplate(w, "register", RegisterPageData{
+		Mode:       h.Config.RegistrationMode,
+		InviteCode: r.URL.Query().Get("invite"),
+	})
+}
+
+// Обработка формы регистрации
+func (h *UserHandler) RegisterFormHandler(w http.ResponseWriter, r *http.Request) {
+	//Получение данных из формы
+	username := strings.TrimSpace(r.FormValue("email"))
+	password := r.FormValue("password")
+	//Проверка заполнения и совпадения паролей
+	if username == "" || password == "" {
+		http.Error(w, "Имя пользователя и пароль обязательны", http.StatusBadRequest)
+		return
+	}
+	if password != r.FormValue("confirm_password") {
+		http.Error(w, "Пароли не совпадают", http.StatusBadRequest)
+		return
+	}
+	//Создание пользователя с учетом режима регистрации
+	if _, err := h.register(username, password, strings.TrimSpace(r.FormValue("invite_code"))); err != nil {
+		http.Error(w, err.Error(), registerErrorStatus(err))
+		return
+	}
+	//Перенаправление на страницу входа
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
 }
 
 // Обработка формы входа
@@ -52,28 +102,89 @@ func (h *UserHandler) LoginFormHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Ошибка при поиске пользователя", http.StatusInternalServerError)
 		return
 	}
+	//Проверка ограничений защиты от перебора
+	ip := clientIP(r, h.Config.TrustProxy)
+	block, err := h.checkLoginAllowed(username, ip, user)
+	if err != nil {
+		log.Printf("Ошибка проверки попыток входа: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if block != nil {
+		setRetryAfter(w, block.retryAfter)
+		http.Error(w, block.message, block.status)
+		return
+	}
 	//Сравнение пароля с хешем в базе данных и обработка ошибок
 	if user == nil || !user.CheckPassword(password) {
+		h.recordLoginFailure(username, ip, user)
 		http.Error(w, "Неверное имя пользователя или пароль", http.StatusUnauthorized)
 		return
 	}
+	//Отключенная учетная запись не может войти
+	if user.Disabled {
+		http.Error(w, "Учетная запись отключена", http.StatusForbidden)
+		return
+	}
+	h.upgradePasswordHash(user, password)
+	//При включенной 2FA показывается форма ввода кода
+	challenge, err := h.beginTwoFactor(user)
+	if err != nil {
+		log.Printf("Ошибка начала второго шага входа: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		renderTwoFactorStep(w, challenge)
+		return
+	}
+	h.recordLoginSuccess(user, ip)
 
-	//Генерация JWT(здесь — user.UserName и криптографическая подпись)
-	tokenString, err := generateToken(user.UserName)
+	//Создание сессии и генерация JWT(здесь — данные пользователя и криптографическая подпись)
+	pair, err := startSession(h.Sessions, h.Config, user, r)
 	if err != nil {
+		log.Printf("Ошибка генерации токена: %v", err)
 		http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
 		return
 	}
 
 	//Установка cookie для передачи данных
-	http.SetCookie(w, &http.Cookie{
-		Name:     "token",                        //имя, под которым будет храниться JWT
-		Value:    tokenString,                    //сам JWT
-		Path:     "/",                            //доступ к cookie всему сайту
-		Expires:  time.Now().Add(24 * time.Hour), //срок действия ключа
-		HttpOnly: true,                           //запрещает доступ клиенту
-		Secure:   false,                          //HTTP-протокол
-	})
+	setSessionCookies(w, pair, h.Config)
+	//Перенаправление на админ-панель
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// страница второго шага входа: форма кода, а если 2FA подключается при входе - еще QR-код
+func renderTwoFactorStep(w http.ResponseWriter, challenge *twoFactorChallenge) {
+	data := LoginPageData{MFAToken: challenge.MFAToken, Enrollment: challenge.Enrollment}
+	if challenge.Enrollment != nil {
+		//data URI сгенерирован сервером, поэтому помечается как безопасный
+		data.QRCode = template.URL(challenge.Enrollment.QRCode)
+	}
+	renderLogin(w, data)
+}
+
+// Обработка формы второго шага входа (код 2FA или код восстановления)
+func (h *UserHandler) TwoFactorFormHandler(w http.ResponseWriter, r *http.Request) {
+	//Проверка кода по токену второго шага
+	user, recoveryCodes, err := h.completeTwoFactor(r.FormValue("mfa_token"), r.FormValue("code"), r.FormValue("recovery_code"), clientIP(r, h.Config.TrustProxy))
+	if err != nil {
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+	//Создание сессии
+	pair, err := startSession(h.Sessions, h.Config, user, r)
+	if err != nil {
+		log.Printf("Ошибка генерации токена: %v", err)
+		http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookies(w, pair, h.Config)
+	//После подключения 2FA коды восстановления показываются один раз
+	if len(recoveryCodes) > 0 {
+		renderLogin(w, LoginPageData{RecoveryCodes: recoveryCodes})
+		return
+	}
 	//Перенаправление на админ-панель
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"cosmetics/config"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Защита HTML-форм от подделки межсайтовых запросов (CSRF):
+// токен формы - HMAC идентификатора сессии, поэтому он свой у каждой сессии,
+// не хранится в БД и не меняется при ротации токена доступа.
+// Проверяются только запросы, авторизованные cookie: клиенты с заголовком Authorization или X-API-Key
+// передают токен явно, и сторонний сайт не может подставить его в форму
+
+// ключ HMAC для токенов форм
+var csrfKey []byte
+
+// загрузка ключа из CSRF_SECRET; без него генерируется случайный ключ,
+// и после перезапуска сервера открытые формы нужно обновить
+func LoadCSRFKey(cfg *config.Config) {
+	if cfg.CSRFSecret != "" {
+		csrfKey = []byte(cfg.CSRFSecret)
+		return
+	}
+	csrfKey = make([]byte, 32)
+	if _, err := rand.Read(csrfKey); err != nil {
+		log.Fatalf("Ошибка генерации ключа CSRF: %v", err)
+	}
+	log.Printf("CSRF_SECRET не задан: используется временный ключ, токены форм недействительны после перезапуска")
+}
+
+// токен форм для сессии
+func csrfToken(sessionID string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte("csrf:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// токен форм для сессии из контекста AuthMiddleware; пустой, если сессии нет
+func csrfTokenFromContext(r *http.Request) string {
+	claims := claimsFromContext(r)
+	if claims == nil {
+		return ""
+	}
+	return csrfToken(claims.SessionID)
+}
+
+// Проверка CSRF-токена изменяющих запросов; подключается после AuthMiddleware
+// Токен передается полем формы csrf_token или заголовком X-CSRF-Token
+func VerifyCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		//запросы, авторизованные токеном доступа в заголовке или API-ключом, не зависят от cookie браузера;
+		//наличие заголовка Authorization само по себе проверку не отменяет
+		if source := authSourceFromContext(r); source == authSourceBearer || source == authSourceAPIKey {
+			next.ServeHTTP(w, r)
+			return
+		}
+		expected := csrfTokenFromContext(r)
+		token := r.Header.Get("X-CSRF-Token")
+		if token == "" {
+			token = r.PostFormValue("csrf_token")
+		}
+		if expected == "" || !hmac.Equal([]byte(strings.TrimSpace(token)), []byte(expected)) {
+			log.Printf("Отклонен запрос %s %s: неверный CSRF-токен", r.Method, r.URL.Path)
+			handleCSRFFailure(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// отказ из-за неверного CSRF-токена: JSON 403 для API, текст для страниц
+func handleCSRFFailure(w http.ResponseWriter, r *http.Request) {
+	const message = "Недействительный CSRF-токен, обновите страницу и повторите действие"
+	if isAPIRequest(r) {
+		handleForbidden(w, message)
+		return
+	}
+	http.Error(w, message, http.StatusForbidden)
+}
+
+// режим SameSite для cookie сессии из настроек
+func sameSiteMode(cfg *config.Config) http.SameSite {
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"cosmetics/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Семейства ингредиентов (таблица ingredient_family) и принадлежность им ингредиентов (structure_family)
+type FamilyHandler struct {
+	Repo       *repository.FamilyRepository
+	Structures *repository.StructureRepository
+	Audit      *repository.AuditRepository
+}
+
+// конструктор экземпляра обработчика
+func NewFamilyHandler(repo *repository.FamilyRepository, structures *repository.StructureRepository, audit *repository.AuditRepository) *FamilyHandler {
+	return &FamilyHandler{Repo: repo, Structures: structures, Audit: audit}
+}
+
+// разбор тела с названием и родителем семейства; лишние пробелы в названии убираются, название из одних цифр отклоняется
+func decodeFamily(r *http.Request) (*models.IngredientFamily, error) {
+	var family models.IngredientFamily
+	if err := json.NewDecoder(r.Body).Decode(&family); err != nil {
+		return nil, err
+	}
+	family.Name = strings.Join(strings.Fields(family.Name), " ")
+	if family.Name == "" {
+		return nil, errors.New("Не указано название семейства")
+	}
+	//число в фильтре family означает id семейства, такое название нельзя было бы выбрать
+	if _, err := strconv.Atoi(family.Name); err == nil {
+		return nil, errors.New("Название семейства не может быть числом")
+	}
+	family.Children = nil
+	return &family, nil
+}
+
+// семейство из параметра id маршрута; при ошибке ответ уже отправлен
+func (h *FamilyHandler) familyFromPath(w http.ResponseWriter, r *http.Request) (*models.IngredientFamily, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор семейства", http.StatusBadRequest)
+		return nil, false
+	}
+	family, err := h.Repo.GetByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if family == nil {
+		http.Error(w, "Семейство не найдено", http.StatusNotFound)
+		return nil, false
+	}
+	return family, true
+}
+
+// обработчик GETAll: дерево семейств с числом ингредиентов и продуктов; flat=1 - плоский список с parent_id
+func (h *FamilyHandler) GetFamilies(w http.ResponseWriter, r *http.Request) {
+	families, err := h.Repo.GetAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("flat") != "1" {
+		families = repository.FamilyTree(families)
+		if families == nil {
+			families = []models.IngredientFamily{}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Семейства ингредиентов получены успешно", Data: families})
+}
+
+// обработчик GET: семейство с дочерними семействами
+func (h *FamilyHandler) GetFamily(w http.ResponseWriter, r *http.Request) {
+	family, ok := h.familyFromPath(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Семейство ингредиентов получено успешно", Data: family})
+}
+
+// обработчик POST
+func (h *FamilyHandler) CreateFamily(w http.ResponseWriter, r *http.Request) {
+	family, err := decodeFamily(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	family.ID = 0
+	if err := h.Repo.Create(family); err != nil {
+		http.Error(w, err.Error(), familyErrorStatus(err))
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditCreate, models.AuditEntityFamily, family.ID, nil, family)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.Response{Message: "Семейство ингредиентов создано успешно", Data: family})
+}
+
+// обработчик PUT: переименование и перенос семейства; parent_id null - в корень дерева
+func (h *FamilyHandler) UpdateFamily(w http.ResponseWriter, r *http.Request) {
+	before, ok := h.familyFromPath(w, r)
+	if !ok {
+		return
+	}
+	family, err := decodeFamily(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	family.ID = before.ID
+	if err := h.Repo.Update(family); err != nil {
+		http.Error(w, err.Error(), familyErrorStatus(err))
+		return
+	}
+	after, err := h.Repo.GetByID(family.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityFamily, family.ID, before, after)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Семейство ингредиентов обновлено успешно", Data: after})
+}
+
+// обработчик DELETE: семейство с вложенными семействами не удаляется; ингредиенты остаются
+func (h *FamilyHandler) DeleteFamily(w http.ResponseWriter, r *http.Request) {
+	family, ok := h.familyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Repo.Delete(family.ID); err != nil {
+		http.Error(w, err.Error(), familyErrorStatus(err))
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditDelete, models.AuditEntityFamily, family.ID, family, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Семейство ингредиентов удалено успешно"})
+}
+
+// обработчик GET: ингредиенты семейства и всех его потомков; direct=1 - только входящие напрямую
+func (h *FamilyHandler) GetFamilyStructures(w http.ResponseWriter, r *http.Request) {
+	family, ok := h.familyFromPath(w, r)
+	if !ok {
+		return
+	}
+	structures, err := h.Repo.Structures(family.ID, r.URL.Query().Get("direct") != "1")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиенты семейства получены успешно", Data: structures})
+}
+
+// обработчик PUT: замена ингредиентов семейства
+func (h *FamilyHandler) SetFamilyStructures(w http.ResponseWriter, r *http.Request) {
+	h.changeFamilyStructures(w, r, h.Repo.SetStructures, "Ингредиенты семейства заменены успешно")
+}
+
+// обработчик POST: добавление ингредиентов в семейство
+func (h *FamilyHandler) AddFamilyStructures(w http.ResponseWriter, r *http.Request) {
+	h.changeFamilyStructures(w, r, h.Repo.AddStructures, "Ингредиенты добавлены в семейство")
+}
+
+// обработчик DELETE: исключение ингредиента из семейства
+func (h *FamilyHandler) RemoveFamilyStructure(w http.ResponseWriter, r *http.Request) {
+	family, ok := h.familyFromPath(w, r)
+	if !ok {
+		return
+	}
+	structureID, err := strconv.Atoi(mux.Vars(r)["structure_id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор ингредиента", http.StatusBadRequest)
+		return
+	}
+	before, err := h.Repo.Structures(family.ID, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	removed, err := h.Repo.RemoveStructure(family.ID, structureID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Ингредиент не входит в семейство", http.StatusNotFound)
+		return
+	}
+	h.writeFamilyStructures(w, r, family, before, "Ингредиент исключен из семейства")
+}
+
+// общая часть замены и добавления: проверка ингредиентов, изменение и ответ с новым списком ингредиентов
+func (h *FamilyHandler) changeFamilyStructures(w http.ResponseWriter, r *http.Request, change func(familyID int, structureIDs []int) error, message string) {
+	family, ok := h.familyFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req productStructuresRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := uniqueIDs(req.StructureIDs)
+	missing, err := h.Structures.Missing(ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(missing) > 0 {
+		http.Error(w, fmt.Sprintf("Ингредиенты не найдены: %v", missing), http.StatusBadRequest)
+		return
+	}
+	before, err := h.Repo.Structures(family.ID, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := change(family.ID, ids); err != nil {
+		log.Printf("Ошибка изменения ингредиентов семейства ID %d: %v", family.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeFamilyStructures(w, r, family, before, message)
+}
+
+// запись изменения в журнал аудита и ответ с ингредиентами, входящими в семейство напрямую
+func (h *FamilyHandler) writeFamilyStructures(w http.ResponseWriter, r *http.Request, family *models.IngredientFamily, before []models.Structure, message string) {
+	after, err := h.Repo.Structures(family.ID, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityFamily, family.ID,
+		map[string]interface{}{"family": family, "structures": before}, map[string]interface{}{"family": family, "structures": after})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: message, Data: after})
+}
+
+// код ответа для ошибки изменения семейства
+func familyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrFamilyExists), errors.Is(err, repository.ErrFamilyHasChildren):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrFamilyNotFound), errors.Is(err, repository.ErrFamilyCycle):
+		return http.StatusBadRequest
+	}
+	log.Printf("Ошибка изменения семейства ингредиентов: %v", err)
+	return http.StatusInternalServerError
+}
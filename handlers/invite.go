@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"cosmetics/config"
+	"cosmetics/models"
+	"cosmetics/repository"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type InviteHandler struct {
+	Repo   *repository.InviteRepository
+	Config *config.Config
+}
+
+// конструктор экземпляра обработчика
+func NewInviteHandler(repo *repository.InviteRepository, cfg *config.Config) *InviteHandler {
+	return &InviteHandler{Repo: repo, Config: cfg}
+}
+
+// тело запроса создания приглашения
+type createInviteRequest struct {
+	Role           models.Role `json:"role"`
+	ExpiresInHours int         `json:"expires_in_hours"`
+}
+
+// обработчик POST: создание приглашения, код возвращается один раз
+func (h *InviteHandler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	var req createInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleViewer
+	}
+	if !req.Role.Valid() {
+		http.Error(w, "Неизвестная роль", http.StatusBadRequest)
+		return
+	}
+	ttl := h.Config.InviteTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	username, _ := r.Context().Value("username").(string)
+	invite := models.Invite{
+		Role:      req.Role,
+		CreatedBy: username,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := h.Repo.Create(&invite); err != nil {
+		log.Printf("Ошибка создания приглашения: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.Response{Message: "Приглашение создано успешно", Data: invite})
+}
+
+// обработчик GETAll
+func (h *InviteHandler) GetInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := h.Repo.GetAll()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Приглашения получены успешно", Data: invites})
+}
+
+// обработчик DELETE: отзыв приглашения
+func (h *InviteHandler) DeleteInvite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор приглашения", http.StatusBadRequest)
+		return
+	}
+	if err := h.Repo.Delete(id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Приглашение удалено успешно"})
+}
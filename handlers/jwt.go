@@ -1,36 +1,73 @@
 package handlers
 
 import (
+	"cosmetics/models"
+	"crypto/rand"
+	"encoding/base64"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 )
 
-// Ключ для криптографической подписи токенов
-var jwtKey = []byte("sdcfghyjukilolikujyhgfdefrgthjkl;kjhgfdgthyjukilo;plkjyhtgrfghjkl;")
-
 // Структура клеймов JWT
 type Claims struct {
-	Username string `json:"username"`
+	UserID    int         `json:"uid"`
+	Username  string      `json:"username"`
+	Role      models.Role `json:"role"`
+	SessionID string      `json:"sid"` // сессия, к которой привязан токен
 	jwt.RegisteredClaims
 }
 
-// Создание JWT-токена для пользователя
-func generateToken(username string) (string, error) {
+// Создание JWT-токена доступа для пользователя в рамках сессии
+func generateToken(user *models.User, sessionID string, ttl time.Duration) (string, error) {
+	//Уникальный идентификатор токена (jti) для списка отзыва
+	tokenID, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
 	//Установка срока действия
-	expirationTime := time.Now().Add(24 * time.Hour)
+	now := time.Now()
 	//СОздание структуры клейма
 	claims := &Claims{
-		Username: username,
+		UserID:    user.ID,
+		Username:  user.UserName,
+		Role:      user.Role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Subject:   username,
+			ID:        tokenID,
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Subject:   user.UserName,
 		},
 	}
-	//Создание токена с подписью
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	//Создание токена с подписью активным ключом, kid указывает ключ для проверки
+	key := jwtKeys.active
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Sign)
 
 	return tokenString, err
 }
+
+// Проверка подписи и срока действия токена, извлечение клеймов
+func parseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || claims.ID == "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// Генерация случайного идентификатора токена
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
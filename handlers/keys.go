@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"cosmetics/config"
+	"cosmetics/models"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// Ключ подписи JWT; ключи без закрытой части используются только для проверки
+type signingKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Sign   interface{} // []byte для HS256, *rsa.PrivateKey, ed25519.PrivateKey; nil - только проверка
+	Verify interface{} // []byte для HS256, *rsa.PublicKey, ed25519.PublicKey
+}
+
+// Набор ключей: активный ключ подписывает новые токены,
+// остальные продолжают принимать ранее выданные токены во время ротации
+type keySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// Загруженные ключи подписи
+var jwtKeys *keySet
+
+// описание ключа в файле JWT_KEYS_FILE
+type keyFileEntry struct {
+	KID            string `json:"kid"`
+	Alg            string `json:"alg"`              // HS256, RS256 или EdDSA
+	Secret         string `json:"secret"`           // секрет для HS256
+	PrivateKeyFile string `json:"private_key_file"` // PEM закрытого ключа для RS256/EdDSA
+	PublicKeyFile  string `json:"public_key_file"`  // PEM открытого ключа (ключ только для проверки)
+}
+
+// формат файла JWT_KEYS_FILE
+type keyFile struct {
+	Active string         `json:"active"` // kid ключа, которым подписываются новые токены
+	Keys   []keyFileEntry `json:"keys"`
+}
+
+// Загрузка ключей подписи из настроек:
+// JWT_KEYS_FILE - набор ключей с ротацией, иначе JWT_SECRET (HS256),
+// иначе случайный секрет, действующий до перезапуска сервера
+func LoadSigningKeys(cfg *config.Config) error {
+	set := &keySet{keys: map[string]*signingKey{}}
+
+	switch {
+	case cfg.JWTKeysFile != "":
+		data, err := os.ReadFile(cfg.JWTKeysFile)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения файла ключей: %w", err)
+		}
+		var file keyFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("ошибка разбора файла ключей: %w", err)
+		}
+		for _, entry := range file.Keys {
+			key, err := parseKeyEntry(entry)
+			if err != nil {
+				return fmt.Errorf("ключ %q: %w", entry.KID, err)
+			}
+			if _, exists := set.keys[key.ID]; exists {
+				return fmt.Errorf("ключ %q указан дважды", key.ID)
+			}
+			set.keys[key.ID] = key
+		}
+		set.active = set.keys[file.Active]
+		if set.active == nil || set.active.Sign == nil {
+			return fmt.Errorf("активный ключ %q не найден или не содержит закрытой части", file.Active)
+		}
+	case cfg.JWTSecret != "":
+		set.active = &signingKey{ID: cfg.JWTKeyID, Method: jwt.SigningMethodHS256, Sign: []byte(cfg.JWTSecret), Verify: []byte(cfg.JWTSecret)}
+		set.keys[set.active.ID] = set.active
+	default:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		log.Println("JWT_SECRET и JWT_KEYS_FILE не заданы: используется временный ключ, токены станут недействительны после перезапуска")
+		set.active = &signingKey{ID: "ephemeral", Method: jwt.SigningMethodHS256, Sign: secret, Verify: secret}
+		set.keys[set.active.ID] = set.active
+	}
+
+	jwtKeys = set
+	log.Printf("Ключи JWT загружены: активный %q (%s), всего %d", set.active.ID, set.active.Method.Alg(), len(set.keys))
+	return nil
+}
+
+// разбор описания ключа из файла
+func parseKeyEntry(entry keyFileEntry) (*signingKey, error) {
+	if entry.KID == "" {
+		return nil, errors.New("не указан kid")
+	}
+	key := &signingKey{ID: entry.KID}
+
+	switch entry.Alg {
+	case "HS256":
+		if entry.Secret == "" {
+			return nil, errors.New("для HS256 требуется secret")
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.Sign = []byte(entry.Secret)
+		key.Verify = key.Sign
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		if entry.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(entry.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.Sign = private
+			key.Verify = &private.PublicKey
+		} else {
+			pem, err := os.ReadFile(entry.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			public, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.Verify = public
+		}
+	case "EdDSA":
+		key.Method = jwt.SigningMethodEdDSA
+		if entry.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(entry.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			edKey, ok := private.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("ожидается закрытый ключ Ed25519")
+			}
+			key.Sign = edKey
+			key.Verify = edKey.Public()
+		} else {
+			pem, err := os.ReadFile(entry.PublicKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			public, err := jwt.ParseEdPublicKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.Verify = public
+		}
+	default:
+		return nil, fmt.Errorf("неподдерживаемый алгоритм %q", entry.Alg)
+	}
+	return key, nil
+}
+
+// Выбор ключа проверки по заголовку kid; алгоритм токена должен совпадать с алгоритмом ключа
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := jwtKeys.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("неизвестный ключ %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("алгоритм %s не соответствует ключу %q", token.Method.Alg(), kid)
+	}
+	return key.Verify, nil
+}
+
+// Обработчик /.well-known/jwks.json: открытые ключи RS256 и EdDSA для проверки токенов другими сервисами
+// Симметричные ключи HS256 не публикуются
+func JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	ids := make([]string, 0, len(jwtKeys.keys))
+	for id := range jwtKeys.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	keys := []models.JSONWebKey{}
+	for _, id := range ids {
+		key := jwtKeys.keys[id]
+		switch public := key.Verify.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, models.JSONWebKey{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, models.JSONWebKey{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(models.JSONWebKeySet{Keys: keys})
+}
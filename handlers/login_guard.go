@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Защита входа от перебора паролей:
+// - после каждой неудачной попытки для логина растет задержка (экспоненциально),
+// - после LoginMaxFailures неудач подряд учетная запись блокируется на LoginLockout,
+// - при большом числе неудач с одного IP задержка включается для всего адреса
+
+// отказ во входе из-за защиты от перебора
+type loginBlock struct {
+	status     int           // 429 (слишком частые попытки) или 423 (учетная запись заблокирована)
+	retryAfter time.Duration // через сколько можно повторить попытку
+	message    string
+}
+
+// проверка ограничений перед сверкой пароля; user равен nil для несуществующего логина
+func (h *UserHandler) checkLoginAllowed(username, ip string, user *models.User) (*loginBlock, error) {
+	cfg := h.Config
+	now := time.Now()
+
+	//ограничение по IP-адресу
+	ipFailures, ipLast, err := h.Attempts.IPFailures(ip, now.Add(-cfg.LoginIPWindow))
+	if err != nil {
+		return nil, err
+	}
+	if ipFailures >= cfg.LoginIPMaxFailures {
+		if until := ipLast.Add(h.backoff(ipFailures - cfg.LoginIPMaxFailures + 1)); until.After(now) {
+			return &loginBlock{http.StatusTooManyRequests, until.Sub(now), "Слишком много неудачных попыток входа с вашего адреса"}, nil
+		}
+	}
+
+	//блокировка учетной записи; для несуществующих логинов считается по журналу,
+	//чтобы ответ не раскрывал, существует ли пользователь
+	failures := 0
+	if user != nil {
+		if user.LockedUntil != nil && user.LockedUntil.After(now) {
+			return &loginBlock{http.StatusLocked, user.LockedUntil.Sub(now), "Учетная запись временно заблокирована"}, nil
+		}
+		//после истечения блокировки прежние неудачи не учитываются
+		if user.LockedUntil == nil {
+			failures = user.FailedLogins
+		}
+	} else {
+		count, last, err := h.Attempts.UsernameFailures(username, now.Add(-cfg.LoginLockout))
+		if err != nil {
+			return nil, err
+		}
+		if count >= cfg.LoginMaxFailures {
+			return &loginBlock{http.StatusLocked, last.Add(cfg.LoginLockout).Sub(now), "Учетная запись временно заблокирована"}, nil
+		}
+		failures = count
+	}
+
+	//задержка после предыдущих неудачных попыток
+	if failures > 0 {
+		_, last, err := h.Attempts.UsernameFailures(username, now.Add(-cfg.LoginBackoffMax))
+		if err != nil {
+			return nil, err
+		}
+		if until := last.Add(h.backoff(failures)); !last.IsZero() && until.After(now) {
+			return &loginBlock{http.StatusTooManyRequests, until.Sub(now), "Слишком частые попытки входа"}, nil
+		}
+	}
+	return nil, nil
+}
+
+// задержка после n неудачных попыток: base * 2^(n-1), не более LoginBackoffMax
+func (h *UserHandler) backoff(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	delay := float64(h.Config.LoginBackoffBase) * math.Pow(2, float64(n-1))
+	if delay > float64(h.Config.LoginBackoffMax) {
+		return h.Config.LoginBackoffMax
+	}
+	return time.Duration(delay)
+}
+
+// учет неудачной попытки входа
+func (h *UserHandler) recordLoginFailure(username, ip string, user *models.User) {
+	if err := h.Attempts.Record(username, ip, false); err != nil {
+		log.Printf("Ошибка записи попытки входа: %v", err)
+	}
+	if user != nil {
+		if err := h.Repo.RegisterFailedLogin(user.ID, h.Config.LoginMaxFailures, h.Config.LoginLockout); err != nil {
+			log.Printf("Ошибка учета неудачного входа: %v", err)
+		}
+	}
+}
+
+// учет успешного входа: запись в журнал и сброс счетчика неудач
+func (h *UserHandler) recordLoginSuccess(user *models.User, ip string) {
+	if err := h.Attempts.Record(user.UserName, ip, true); err != nil {
+		log.Printf("Ошибка записи попытки входа: %v", err)
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.Repo.ResetLoginFailures(user.ID); err != nil {
+			log.Printf("Ошибка сброса счетчика неудачных входов: %v", err)
+		}
+	}
+}
+
+// установка заголовка Retry-After (в целых секундах, с округлением вверх)
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// JSON-ответ об отказе во входе
+func writeLoginBlock(w http.ResponseWriter, block *loginBlock) {
+	setRetryAfter(w, block.retryAfter)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(block.status)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   http.StatusText(block.status),
+		Message: block.message,
+	})
+}
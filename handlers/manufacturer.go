@@ -4,19 +4,31 @@ import (
 	"cosmetics/models"
 	"cosmetics/repository"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
 type ManufacturerHandler struct {
-	Repo *repository.ManufacturerRepository
+	Repo  *repository.ManufacturerRepository
+	Audit *repository.AuditRepository
 }
 
 // конструктор экземпляра обработчика
-func NewManufacturerHandler(repo *repository.ManufacturerRepository) *ManufacturerHandler {
-	return &ManufacturerHandler{Repo: repo}
+func NewManufacturerHandler(repo *repository.ManufacturerRepository, audit *repository.AuditRepository) *ManufacturerHandler {
+	return &ManufacturerHandler{Repo: repo, Audit: audit}
+}
+
+// состояние производителя для журнала аудита; nil, если производитель не найден
+func (h *ManufacturerHandler) snapshot(id int) *models.Manufacturer {
+	manufacturer, err := h.Repo.GetByID(id)
+	if err != nil {
+		return nil
+	}
+	return manufacturer
 }
 
 // обработчик POST
@@ -26,24 +38,39 @@ func (h *ManufacturerHandler) CreateManufacturer(w http.ResponseWriter, r *http.
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	manufacturer.ID = 0
+	if errs := manufacturer.Validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
 	if err := h.Repo.Create(&manufacturer); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(h.Audit, r, models.AuditCreate, models.AuditEntityManufacturer, manufacturer.ID, nil, manufacturer)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(models.Response{Message: "Производитель создан успешно", Data: manufacturer})
 }
 
-// обработчик GETAll
+// обработчик GETAll: постранично, с фильтрами country и query и сортировкой sort: id, title, country
 func (h *ManufacturerHandler) GetManufacturers(w http.ResponseWriter, r *http.Request) {
-	manufacturers, err := h.Repo.GetAll()
+	page, err := parsePage(r, defaultPageLimit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := repository.ManufacturerFilter{
+		Country: strings.TrimSpace(r.URL.Query().Get("country")),
+		Query:   strings.TrimSpace(r.URL.Query().Get("query")),
+	}
+	manufacturers, info, err := h.Repo.List(filter, page)
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.Response{Message: "Производители получены успешно", Data: manufacturers})
+	json.NewEncoder(w).Encode(models.Response{Message: "Производители получены успешно", Data: manufacturers, Pagination: pagination(r, page, info)})
 }
 
 // обработчик GET
@@ -77,10 +104,20 @@ func (h *ManufacturerHandler) UpdateManufacturer(w http.ResponseWriter, r *http.
 		return
 	}
 	manufacturer.ID = id
+	if errs := manufacturer.Validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	before := h.snapshot(id)
+	if before == nil {
+		http.Error(w, "Производитель не найден", http.StatusNotFound)
+		return
+	}
 	if err := h.Repo.Update(&manufacturer); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityManufacturer, id, before, manufacturer)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.Response{Message: "Производитель обновлен успешно", Data: manufacturer})
 }
@@ -93,10 +130,26 @@ func (h *ManufacturerHandler) DeleteManufacturer(w http.ResponseWriter, r *http.
 		http.Error(w, "Неверный идентификатор производителя", http.StatusBadRequest)
 		return
 	}
+	before := h.snapshot(id)
+	if before == nil {
+		http.Error(w, "Производитель не найден", http.StatusNotFound)
+		return
+	}
+	//производитель с продуктами не удаляется, чтобы не оставить продукты без производителя
+	count, err := h.Repo.CountProducts(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, fmt.Sprintf("У производителя есть продукты (%d), удаление невозможно", count), http.StatusConflict)
+		return
+	}
 	if err := h.Repo.Delete(id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(h.Audit, r, models.AuditDelete, models.AuditEntityManufacturer, id, before, nil)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.Response{Message: "Производитель удален успешно"})
 }
@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"context"
+	"cosmetics/config"
+	"cosmetics/models"
+	"cosmetics/repository"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Вход через OpenID Connect (authorization code + PKCE):
+// - /login/oidc перенаправляет к провайдеру, сохраняя state, nonce и code_verifier в cookie,
+// - /login/oidc/callback обменивает код на токены и проверяет подпись, издателя, аудиторию и nonce ID-токена,
+// - внешняя учетная запись (issuer + sub) сопоставляется с пользователем, роль по группам назначается
+//   только пользователям, созданным при входе через провайдера,
+// - при включенной или обязательной для роли 2FA вход завершается тем же вторым шагом, что и вход по паролю,
+// - дальше выдаются обычные cookie сессии, как после входа по паролю
+
+// cookie с состоянием незавершенного входа через провайдера
+const oidcStateCookie = "oidc_state"
+
+// время на вход у провайдера
+const oidcStateTTL = 10 * time.Minute
+
+// ошибки входа через OIDC
+var (
+	errOIDCState         = errors.New("Сеанс входа через провайдера недействителен или истек, начните вход заново")
+	errOIDCNoRole        = errors.New("Группы учетной записи не дают доступа к каталогу")
+	errOIDCUsernameTaken = errors.New("Пользователь с таким логином уже существует и не связан с этой учетной записью")
+)
+
+type OIDCHandler struct {
+	Users      *UserHandler
+	Identities *repository.IdentityRepository
+	Config     *config.Config
+
+	mu       sync.Mutex
+	provider *oidc.Provider // результат discovery, запрашивается при первом входе
+}
+
+// конструктор для создания экземпляра с внедренными зависимостями
+func NewOIDCHandler(users *UserHandler, identities *repository.IdentityRepository, cfg *config.Config) *OIDCHandler {
+	return &OIDCHandler{Users: users, Identities: identities, Config: cfg}
+}
+
+// состояние входа, хранимое в cookie до возврата от провайдера
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"` // PKCE code_verifier
+}
+
+// провайдер и настройки клиента OAuth2; discovery повторяется, пока провайдер недоступен
+func (h *OIDCHandler) client(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.provider == nil {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		provider, err := oidc.NewProvider(ctx, h.Config.OIDCIssuer)
+		if err != nil {
+			return nil, nil, err
+		}
+		h.provider = provider
+	}
+	return h.provider, &oauth2.Config{
+		ClientID:     h.Config.OIDCClientID,
+		ClientSecret: h.Config.OIDCClientSecret,
+		RedirectURL:  h.Config.OIDCRedirectURL,
+		Endpoint:     h.provider.Endpoint(),
+		Scopes:       h.Config.OIDCScopes,
+	}, nil
+}
+
+// обработка GET-запроса начала входа: перенаправление на страницу входа провайдера
+func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
+	_, oauthConfig, err := h.client(r.Context())
+	if err != nil {
+		log.Printf("Ошибка обращения к провайдеру OIDC: %v", err)
+		http.Error(w, "Провайдер входа недоступен", http.StatusBadGateway)
+		return
+	}
+	state := oidcState{Verifier: oauth2.GenerateVerifier()}
+	if state.State, err = newTokenID(); err == nil {
+		state.Nonce, err = newTokenID()
+	}
+	if err != nil {
+		log.Printf("Ошибка генерации состояния OIDC: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	value, _ := json.Marshal(state)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.Config.CookieSecure,
+		SameSite: http.SameSiteLaxMode, //cookie нужна при возврате от провайдера по ссылке
+	})
+	http.Redirect(w, r, oauthConfig.AuthCodeURL(state.State,
+		oauth2.S256ChallengeOption(state.Verifier), oidc.Nonce(state.Nonce)), http.StatusFound)
+}
+
+// извлечение и удаление cookie состояния; проверка параметра state
+func (h *OIDCHandler) takeState(w http.ResponseWriter, r *http.Request) (*oidcState, error) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, errOIDCState
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/login/oidc", MaxAge: -1, HttpOnly: true})
+
+	raw, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, errOIDCState
+	}
+	var state oidcState
+	if err := json.Unmarshal(raw, &state); err != nil || state.State == "" {
+		return nil, errOIDCState
+	}
+	if subtle.ConstantTimeCompare([]byte(state.State), []byte(r.URL.Query().Get("state"))) != 1 {
+		return nil, errOIDCState
+	}
+	return &state, nil
+}
+
+// обработка GET-запроса возврата от провайдера
+func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
+	if reason := r.URL.Query().Get("error"); reason != "" {
+		http.Error(w, "Провайдер отклонил вход: "+reason, http.StatusUnauthorized)
+		return
+	}
+	state, err := h.takeState(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	provider, oauthConfig, err := h.client(r.Context())
+	if err != nil {
+		log.Printf("Ошибка обращения к провайдеру OIDC: %v", err)
+		http.Error(w, "Провайдер входа недоступен", http.StatusBadGateway)
+		return
+	}
+
+	//обмен кода на токены с подтверждением PKCE
+	token, err := oauthConfig.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		log.Printf("Ошибка обмена кода OIDC: %v", err)
+		http.Error(w, "Не удалось завершить вход через провайдера", http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Провайдер не вернул ID-токен", http.StatusUnauthorized)
+		return
+	}
+	//проверка подписи по JWKS провайдера, издателя, аудитории и срока действия
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: h.Config.OIDCClientID}).Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.Printf("Отклонен ID-токен OIDC: %v", err)
+		http.Error(w, "Недействительный ID-токен", http.StatusUnauthorized)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		http.Error(w, "Недействительный ID-токен", http.StatusUnauthorized)
+		return
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		http.Error(w, "Недействительный ID-токен", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.resolveUser(idToken.Issuer, idToken.Subject, claims)
+	if err != nil {
+		http.Error(w, err.Error(), oidcErrorStatus(err))
+		return
+	}
+	if user.Disabled {
+		http.Error(w, "Учетная запись отключена", http.StatusForbidden)
+		return
+	}
+	//провайдер заменяет только пароль: код 2FA запрашивается так же, как при входе по паролю
+	challenge, err := h.Users.beginTwoFactor(user)
+	if err != nil {
+		log.Printf("Ошибка начала второго шага входа: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		renderTwoFactorStep(w, challenge)
+		return
+	}
+	h.Users.recordLoginSuccess(user, clientIP(r, h.Config.TrustProxy))
+
+	pair, err := startSession(h.Users.Sessions, h.Config, user, r)
+	if err != nil {
+		log.Printf("Ошибка генерации токена: %v", err)
+		http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
+		return
+	}
+	setSessionCookies(w, pair, h.Config)
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+// код ответа для ошибки сопоставления учетной записи
+func oidcErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errOIDCNoRole):
+		return http.StatusForbidden
+	case errors.Is(err, errOIDCUsernameTaken):
+		return http.StatusConflict
+	}
+	log.Printf("Ошибка входа через OIDC: %v", err)
+	return http.StatusInternalServerError
+}
+
+// Поиск или создание пользователя для внешней учетной записи
+// Роль синхронизируется с группами провайдера при каждом входе только у пользователей, созданных
+// через провайдера; роль привязанной локальной учетной записи меняет только администратор
+func (h *OIDCHandler) resolveUser(issuer, subject string, claims map[string]interface{}) (*models.User, error) {
+	role, ok := h.mapRole(claims)
+	if !ok {
+		return nil, errOIDCNoRole
+	}
+
+	var user *models.User
+	userID, created, err := h.Identities.GetUserID(issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != 0 {
+		if user, err = h.Users.Repo.GetUserByID(userID); err != nil {
+			return nil, err
+		}
+		if user == nil {
+			return nil, errors.New("привязанный пользователь не найден")
+		}
+	} else {
+		if user, created, err = h.firstLogin(issuer, subject, claims, role); err != nil {
+			return nil, err
+		}
+	}
+
+	if created && user.Role != role {
+		if err := h.Users.Repo.UpdateRole(user.ID, role); err != nil {
+			return nil, err
+		}
+		log.Printf("Роль пользователя '%s' изменена на %s по группам провайдера", user.UserName, role)
+		user.Role = role
+	}
+	if err := h.Identities.Touch(issuer, subject); err != nil {
+		log.Printf("Ошибка обновления времени входа OIDC: %v", err)
+	}
+	return user, nil
+}
+
+// Первый вход внешней учетной записи: привязка к существующему пользователю с тем же подтвержденным
+// email (его роль не меняется) или создание нового пользователя без локального пароля;
+// true, если пользователь создан
+func (h *OIDCHandler) firstLogin(issuer, subject string, claims map[string]interface{}, role models.Role) (*models.User, bool, error) {
+	username := claimString(claims, h.Config.OIDCUsernameClaim)
+	if username == "" {
+		username = claimString(claims, "preferred_username")
+	}
+	if username == "" {
+		username = subject
+	}
+
+	existing, err := h.Users.Repo.GetUserByUsername(username)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		//привязка по логину допустима, только если провайдер подтвердил владение адресом
+		verified, _ := claims["email_verified"].(bool)
+		if h.Config.OIDCUsernameClaim != "email" || !verified {
+			return nil, false, errOIDCUsernameTaken
+		}
+		if err := h.Identities.Link(existing.ID, issuer, subject); err != nil {
+			return nil, false, err
+		}
+		log.Printf("Учетная запись провайдера привязана к пользователю '%s'", existing.UserName)
+		return existing, false, nil
+	}
+
+	//случайный пароль не сообщается никому: такой пользователь входит только через провайдера
+	password, err := newTokenID()
+	if err != nil {
+		return nil, false, err
+	}
+	user := &models.User{UserName: username, Role: role}
+	if err := user.SetPassword(password); err != nil {
+		return nil, false, err
+	}
+	if err := h.Identities.CreateUser(user, issuer, subject); err != nil {
+		return nil, false, err
+	}
+	log.Printf("Создан пользователь '%s' (%s) по учетной записи провайдера", user.UserName, user.Role)
+	return user, true, nil
+}
+
+// роль по группам из ID-токена: наибольшая из сопоставленных, иначе роль по умолчанию
+func (h *OIDCHandler) mapRole(claims map[string]interface{}) (models.Role, bool) {
+	rank := []models.Role{models.RoleViewer, models.RoleEditor, models.RoleAdmin}
+	best := -1
+	for _, group := range claimStrings(claims[h.Config.OIDCGroupsClaim]) {
+		role := models.Role(h.Config.OIDCRoleMapping[group])
+		if i := slices.Index(rank, role); i > best {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return rank[best], true
+	}
+	if role := models.Role(h.Config.OIDCDefaultRole); role.Valid() {
+		return role, true
+	}
+	return "", false
+}
+
+// строковое утверждение ID-токена
+func claimString(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// утверждение со списком строк (провайдеры передают группы массивом или одной строкой)
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
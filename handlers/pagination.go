@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"cosmetics/repository"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// размер страницы списков по умолчанию и наибольший
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// Разбор параметров страницы: limit, offset, sort (ключ, с минусом - по убыванию) и cursor
+// Курсор задает сортировку сам, параметры sort и offset при нем не учитываются
+func parsePage(r *http.Request, defaultLimit int) (repository.Page, error) {
+	query := r.URL.Query()
+	page := repository.Page{Sort: "id", Limit: defaultLimit}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return page, fmt.Errorf("Параметр limit должен быть числом от 1 до %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := repository.DecodeCursor(value)
+		if err != nil {
+			return page, errors.New("Неверный параметр cursor")
+		}
+		page.Cursor, page.Sort, page.Desc = cursor, cursor.Sort, cursor.Desc
+		return page, nil
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return page, errors.New("Параметр offset должен быть неотрицательным числом")
+		}
+		page.Offset = offset
+	}
+	if value := strings.TrimSpace(query.Get("sort")); value != "" {
+		page.Desc = strings.HasPrefix(value, "-")
+		page.Sort = strings.TrimPrefix(value, "-")
+	}
+	return page, nil
+}
+
+// Сведения о странице для ответа
+// Ссылки next и prev сохраняют параметры запроса; переход по смещению используется, если клиент
+// сам передал offset или список не поддерживает курсоры (поиск по релевантности), иначе ссылки содержат курсор
+func pagination(r *http.Request, page repository.Page, info repository.PageInfo) *models.Pagination {
+	p := &models.Pagination{Total: info.Total, Limit: page.Limit, Offset: page.Offset}
+	byOffset := page.Cursor == nil && (r.URL.Query().Has("offset") || info.First == nil)
+	link := func(set func(q url.Values)) string {
+		q := r.URL.Query()
+		q.Del("cursor")
+		q.Del("offset")
+		set(q)
+		return r.URL.Path + "?" + q.Encode()
+	}
+	if info.HasNext {
+		if byOffset {
+			p.Next = link(func(q url.Values) { q.Set("offset", strconv.Itoa(page.Offset+page.Limit)) })
+		} else if info.Last != nil {
+			p.Next = link(func(q url.Values) { q.Set("cursor", info.Last.Encode()) })
+		}
+	}
+	if info.HasPrev {
+		if byOffset {
+			prev := page.Offset - page.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			p.Prev = link(func(q url.Values) { q.Set("offset", strconv.Itoa(prev)) })
+		} else if info.First != nil {
+			p.Prev = link(func(q url.Values) { q.Set("cursor", info.First.Encode()) })
+		}
+	}
+	return p
+}
+
+// код ответа для ошибки выборки страницы
+func pageErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrInvalidSort) || errors.Is(err, repository.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"cosmetics/config"
+	"cosmetics/models"
+	"cosmetics/repository"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// Политика паролей из настроек сервера
+func PasswordPolicy(cfg *config.Config) models.PasswordPolicy {
+	return models.PasswordPolicy{
+		MinLength:      cfg.PasswordMinLength,
+		RequireUpper:   cfg.PasswordRequireUpper,
+		RequireLower:   cfg.PasswordRequireLower,
+		RequireDigit:   cfg.PasswordRequireDigit,
+		RequireSpecial: cfg.PasswordRequireSpecial,
+		RejectCommon:   cfg.PasswordRejectCommon,
+	}
+}
+
+// тело запроса смены пароля
+type changePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// тело запроса сброса пароля по токену
+type resetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// обработка POST-запроса смены собственного пароля (требуется старый пароль)
+// остальные сессии пользователя после смены завершаются
+func (h *UserHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	claims := claimsFromContext(r)
+	user, err := h.Repo.GetUserByID(claims.UserID)
+	if err != nil {
+		log.Printf("Ошибка при поиске пользователя: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || !user.CheckPassword(req.OldPassword) {
+		handleUnauthorized(w, "Неверный текущий пароль")
+		return
+	}
+	if err := PasswordPolicy(h.Config).Validate(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.setPassword(user, req.NewPassword); err != nil {
+		log.Printf("Ошибка смены пароля: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Sessions.RevokeAllForUserExcept(user.ID, claims.SessionID); err != nil {
+		log.Printf("Ошибка отзыва сессий после смены пароля: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Пароль изменен успешно"})
+}
+
+// обработка POST-запроса администратора: выдача одноразового токена сброса пароля
+func (h *UserHandler) CreatePasswordReset(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Repo.GetUserByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	}
+	username, _ := r.Context().Value("username").(string)
+	token, expiresAt, err := h.Resets.Create(user.ID, username, h.Config.PasswordResetTTL)
+	if err != nil {
+		log.Printf("Ошибка создания токена сброса пароля: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Пользователь '%s' выдал токен сброса пароля для '%s'", username, user.UserName)
+	recordAudit(h.Audit, r, models.AuditPasswordReset, models.AuditEntityUser, user.ID, nil, nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.Response{
+		Message: "Токен сброса пароля создан",
+		Data: map[string]interface{}{
+			"token":      token,
+			"reset_url":  "/reset-password?token=" + token,
+			"expires_at": expiresAt,
+		},
+	})
+}
+
+// обработка POST-запроса сброса пароля по одноразовому токену
+func (h *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	if err := h.resetPassword(req.Token, req.NewPassword); err != nil {
+		http.Error(w, err.Error(), resetErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Пароль изменен успешно"})
+}
+
+// Отрисовка страницы сброса пароля
+func (h *UserHandler) ResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	//Загрузка и парсинг шаблона сброса пароля
+	tmpl, err := template.ParseFiles("views/reset-password.html")
+	//Обработка ошибок
+	if err != nil {
+		http.Error(w, "Ошибка загрузки страницы сброса пароля", http.StatusInternalServerError)
+		return
+	}
+	//Передача заголовка браузеру
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	//Выполняет шаблон
+	tmpl.ExecuteTemplate(w, "reset-password", map[string]string{"Token": r.URL.Query().Get("token")})
+}
+
+// Обработка формы сброса пароля
+func (h *UserHandler) ResetPasswordFormHandler(w http.ResponseWriter, r *http.Request) {
+	password := r.FormValue("password")
+	if password != r.FormValue("confirm_password") {
+		http.Error(w, "Пароли не совпадают", http.StatusBadRequest)
+		return
+	}
+	if err := h.resetPassword(r.FormValue("token"), password); err != nil {
+		http.Error(w, err.Error(), resetErrorStatus(err))
+		return
+	}
+	//Перенаправление на страницу входа
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// сброс пароля по токену: проверка политики, погашение токена, завершение всех сессий и снятие блокировки
+func (h *UserHandler) resetPassword(token, password string) error {
+	if token == "" {
+		return repository.ErrResetTokenInvalid
+	}
+	if err := PasswordPolicy(h.Config).Validate(password); err != nil {
+		return err
+	}
+	userID, err := h.Resets.Consume(token)
+	if err != nil {
+		return err
+	}
+	user, err := h.Repo.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return repository.ErrResetTokenInvalid
+	}
+	if err := h.setPassword(user, password); err != nil {
+		return err
+	}
+	if err := h.Sessions.RevokeAllForUser(user.ID); err != nil {
+		log.Printf("Ошибка отзыва сессий после сброса пароля: %v", err)
+	}
+	if err := h.Repo.ResetLoginFailures(user.ID); err != nil {
+		log.Printf("Ошибка снятия блокировки после сброса пароля: %v", err)
+	}
+	return nil
+}
+
+// код ответа для ошибки сброса пароля
+func resetErrorStatus(err error) int {
+	switch {
+	case models.IsPasswordPolicyError(err):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrResetTokenInvalid):
+		return http.StatusBadRequest
+	}
+	log.Printf("Ошибка сброса пароля: %v", err)
+	return http.StatusInternalServerError
+}
+
+// хеширование и сохранение нового пароля
+func (h *UserHandler) setPassword(user *models.User, password string) error {
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+	return h.Repo.UpdatePassword(user.ID, user.Password)
+}
+
+// пересчет хеша при входе, если стоимость bcrypt в настройках была повышена
+func (h *UserHandler) upgradePasswordHash(user *models.User, password string) {
+	if !user.NeedsRehash() {
+		return
+	}
+	if err := h.setPassword(user, password); err != nil {
+		log.Printf("Ошибка пересчета хеша пароля: %v", err)
+	}
+}
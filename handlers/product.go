@@ -1,32 +1,77 @@
 package handlers
 
 import (
+	"cosmetics/config"
 	"cosmetics/models"
 	"cosmetics/repository"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 )
 
+// число подсказок строки поиска по умолчанию и наибольшее
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 20
+)
+
+// Наибольшая длина строки поиска в символах и число слов в ней: каждое слово добавляет параметры
+// запроса LIKE, а слово без результатов сравнивается со всем словарем каталога
+const (
+	maxSearchRunes = 200
+	maxSearchWords = 10
+)
+
 type ProductHandler struct {
-	Repo *repository.ProductRepository
+	Repo          *repository.ProductRepository
+	Manufacturers *repository.ManufacturerRepository
+	Audit         *repository.AuditRepository
+	Views         *viewCounter
 }
 
 // инициализация обработчика
-func NewProductHandler(repo *repository.ProductRepository) *ProductHandler {
-	return &ProductHandler{Repo: repo}
+func NewProductHandler(repo *repository.ProductRepository, manufacturers *repository.ManufacturerRepository, audit *repository.AuditRepository, cfg *config.Config) *ProductHandler {
+	return &ProductHandler{Repo: repo, Manufacturers: manufacturers, Audit: audit, Views: newViewCounter(cfg.ViewCountWindow, cfg.TrustProxy)}
+}
+
+// состояние продукта для журнала аудита; nil, если продукт не найден
+func (h *ProductHandler) snapshot(id int) *models.Product {
+	product, err := h.Repo.GetByID(id)
+	if err != nil {
+		return nil
+	}
+	return product
+}
+
+// Проверка продукта по правилам модели и существования производителя в БД
+// errs содержит ошибки полей; error - только ошибка обращения к БД
+func (h *ProductHandler) validate(product *models.Product) (models.ValidationErrors, error) {
+	errs := product.Validate()
+	if !errs.Has("manufacturer_id") {
+		if _, err := h.Manufacturers.GetByID(product.ManufacturerID); err == sql.ErrNoRows {
+			errs = errs.Add("manufacturer_id", "not_found", "Производитель не найден")
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return errs, nil
 }
 
-// извлечение и преобразование данных из HTTP запроса
+// Извлечение и преобразование данных из HTTP запроса
+// Ошибки формата чисел возвращаются вместе с остальными ошибками полей в models.ValidationErrors
 func parseProductForm(r *http.Request, id int) (*models.Product, error) {
 	if err := r.ParseForm(); err != nil {
 		return nil, fmt.Errorf("ошибка парсинга формы: %w", err)
 	}
+	var errs models.ValidationErrors
 
 	title := r.PostFormValue("title")
 	description := r.PostFormValue("description")
@@ -35,14 +80,14 @@ func parseProductForm(r *http.Request, id int) (*models.Product, error) {
 
 	volumeStr := r.PostFormValue("volume")
 	manufacturerIDStr := r.PostFormValue("manufacturer_id")
-	volume, err := strconv.ParseFloat(volumeStr, 64)
+	volume, err := strconv.ParseFloat(strings.TrimSpace(volumeStr), 64)
 	if err != nil {
-		return nil, fmt.Errorf("Неверный формат объема: %w", err)
+		errs = errs.Add("volume", "invalid", "Неверный формат объема")
 	}
 
-	manufacturerID, err := strconv.Atoi(manufacturerIDStr)
+	manufacturerID, err := strconv.Atoi(strings.TrimSpace(manufacturerIDStr))
 	if err != nil {
-		return nil, fmt.Errorf("Неверный формат ID производителя: %w", err)
+		errs = errs.Add("manufacturer_id", "invalid", "Неверный формат ID производителя")
 	}
 	contraindicationsStr := strings.TrimSpace(r.PostFormValue("contraindications"))
 	var contraindications *string
@@ -50,7 +95,7 @@ func parseProductForm(r *http.Request, id int) (*models.Product, error) {
 		contraindications = &contraindicationsStr
 	}
 
-	return &models.Product{
+	product := &models.Product{
 		ID:                id,
 		Title:             title,
 		Description:       description,
@@ -59,11 +104,51 @@ func parseProductForm(r *http.Request, id int) (*models.Product, error) {
 		Volume:            volume,
 		Photo:             photo,
 		ManufacturerID:    manufacturerID,
-	}, nil
+	}
+	//поле состава есть в форме: состав заменяется ингредиентами строки INCI, пустая строка очищает состав
+	if _, ok := r.PostForm["inci"]; ok {
+		product.Structures = []models.Structure{}
+		for _, ingredient := range models.ParseINCI(r.PostFormValue("inci")) {
+			product.Structures = append(product.Structures, ingredient.Structure())
+		}
+	}
+	if len(errs) > 0 {
+		return product, errs
+	}
+	return product, nil
+}
+
+// Разбор и проверка формы продукта
+// При ошибках полей форма показывается заново с кодом 422; false - ответ уже отправлен
+func (h *ProductHandler) productFromForm(w http.ResponseWriter, r *http.Request, admin *AdminPage, id int) (*models.Product, bool) {
+	product, err := parseProductForm(r, id)
+	errs, isValidation := models.AsValidationErrors(err)
+	if err != nil && !isValidation {
+		log.Printf("Ошибка формы продукта: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	checked, err := h.validate(product)
+	if err != nil {
+		log.Printf("Ошибка проверки продукта: %v", err)
+		http.Error(w, "Ошибка проверки продукта", http.StatusInternalServerError)
+		return nil, false
+	}
+	//ошибки формата чисел важнее ошибок правил для тех же полей
+	for _, e := range checked {
+		errs = errs.Add(e.Field, e.Code, e.Message)
+	}
+	if len(errs) > 0 {
+		log.Printf("Форма продукта не прошла проверку: %v", errs)
+		admin.render(w, r, http.StatusUnprocessableEntity, &ProductForm{ID: id, Values: r.PostForm, Errors: errs})
+		return nil, false
+	}
+	return product, true
 }
 
 // Обработка POST/PUT/DELETE с форм и редирект на админ-панель
-func HandleProductFormSubmission(p *ProductHandler) http.HandlerFunc {
+// При ошибках проверки админ-панель показывается заново с ошибками у полей формы
+func HandleProductFormSubmission(p *ProductHandler, admin *AdminPage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		idStr, ok := vars["id"]
@@ -81,40 +166,54 @@ func HandleProductFormSubmission(p *ProductHandler) http.HandlerFunc {
 		r.ParseForm()
 		method := r.PostFormValue("_method")
 
+		//проверка прав роли для запрошенной операции
+		perm := models.PermProductsWrite
 		if method == "DELETE" {
+			perm = models.PermProductsDelete
+		}
+		if !can(r, perm) {
+			http.Error(w, "Недостаточно прав для выполнения операции", http.StatusForbidden)
+			return
+		}
+
+		if method == "DELETE" {
+			before := p.snapshot(id)
 			if err := p.Repo.Delete(id); err != nil {
 				log.Printf("Ошибка удаления продукта ID %d: %v", id, err)
 				http.Error(w, "Ошибка удаления продукта", http.StatusInternalServerError)
 				return
 			}
+			recordAudit(p.Audit, r, models.AuditDelete, models.AuditEntityProduct, id, before, nil)
 			log.Printf("Успешное удаление продукта ID %d. Редирект на /admin", id)
 			http.Redirect(w, r, "/admin", http.StatusSeeOther)
 			return
 		}
 
 		if method == "PUT" {
-			product, err := parseProductForm(r, id)
-			if err != nil {
-				log.Printf("Ошибка формы обновления: %v", err)
-				http.Error(w, err.Error(), http.StatusBadRequest)
+			product, ok := p.productFromForm(w, r, admin, id)
+			if !ok {
 				return
 			}
 
+			before := p.snapshot(id)
+			//неизмененный состав не заменяется, чтобы сохранить концентрации ингредиентов
+			if before != nil && product.Structures != nil && models.SameComposition(models.ParseINCI(r.PostFormValue("inci")), before.Structures) {
+				product.Structures = nil
+			}
 			if err := p.Repo.Update(product); err != nil {
 				log.Printf("Ошибка обновления продукта ID %d: %v", id, err)
 				http.Error(w, "Ошибка обновления продукта", http.StatusInternalServerError)
 				return
 			}
+			recordAudit(p.Audit, r, models.AuditUpdate, models.AuditEntityProduct, id, before, p.snapshot(id))
 			log.Printf("Успешное обновление продукта ID %d. Редирект на /admin", id)
 			http.Redirect(w, r, "/admin", http.StatusSeeOther)
 			return
 		}
 
 		if r.Method == "POST" && method == "" {
-			product, err := parseProductForm(r, 0)
-			if err != nil {
-				log.Printf("Ошибка формы создания: %v", err)
-				http.Error(w, err.Error(), http.StatusBadRequest)
+			product, ok := p.productFromForm(w, r, admin, 0)
+			if !ok {
 				return
 			}
 
@@ -123,6 +222,7 @@ func HandleProductFormSubmission(p *ProductHandler) http.HandlerFunc {
 				http.Error(w, "Ошибка создания продукта", http.StatusInternalServerError)
 				return
 			}
+			recordAudit(p.Audit, r, models.AuditCreate, models.AuditEntityProduct, product.ID, nil, p.snapshot(product.ID))
 			log.Printf("Успешное создание продукта. Редирект на /admin")
 			http.Redirect(w, r, "/admin", http.StatusSeeOther)
 			return
@@ -131,32 +231,194 @@ func HandleProductFormSubmission(p *ProductHandler) http.HandlerFunc {
 	}
 }
 
-// Обработчик добавления
+// Обработчик добавления: JSON продукта, состав в поле structures (id или name ингредиента)
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	var product models.Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	product.ID = 0
+	if !h.checkProduct(w, &product) {
+		return
+	}
 	if err := h.Repo.Create(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeProductError(w, err)
 		return
 	}
+	created := h.snapshot(product.ID)
+	recordAudit(h.Audit, r, models.AuditCreate, models.AuditEntityProduct, product.ID, nil, created)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(models.Response{Message: "Продукт создан успешно", Data: product})
+	json.NewEncoder(w).Encode(models.Response{Message: "Продукт создан успешно", Data: created})
 
 }
 
-// Обработчик получения всех продуктов
+// Обработчик получения списка продуктов постранично
+// Фильтры manufacturer_id, country, min_volume, max_volume и query, сортировка sort: id, title, volume, manufacturer
 func (h *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
-	products, err := h.Repo.GetAll()
+	page, err := parsePage(r, defaultPageLimit)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseProductFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	products, info, err := h.Repo.List(filter, page)
+	if err != nil {
+		http.Error(w, err.Error(), pageErrorStatus(err))
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.Response{Message: "Продукты получены успешно", Data: products})
+	json.NewEncoder(w).Encode(models.Response{Message: "Продукты получены успешно", Data: products, Pagination: pagination(r, page, info)})
+}
+
+// Обработчик поиска продуктов: q - строка поиска ("фраза", слово*), фильтры как у списка продуктов,
+// результаты по убыванию релевантности с фрагментом текста, постранично по limit и offset
+// Если ничего не найдено, поиск повторяется с исправленными опечатками, исправленный запрос - в did_you_mean
+func (h *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	page, err := parsePage(r, defaultPageLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseProductFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if err := checkSearchQuery("q", query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, info, corrected, err := h.Repo.SearchCorrected(query, filter, page)
+	if err != nil {
+		http.Error(w, err.Error(), searchErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Поиск выполнен успешно", Data: results, Pagination: pagination(r, page, info), DidYouMean: corrected})
+}
+
+// Обработчик подсказок для строки поиска: q - начало слова, limit - число подсказок (по умолчанию 10)
+func (h *ProductHandler) SuggestProducts(w http.ResponseWriter, r *http.Request) {
+	limit := defaultSuggestLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxSuggestLimit {
+			http.Error(w, fmt.Sprintf("Параметр limit должен быть числом от 1 до %d", maxSuggestLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	query := r.URL.Query().Get("q")
+	if err := checkSearchQuery("q", query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	suggestions, err := h.Repo.Suggest(query, limit)
+	if err != nil {
+		http.Error(w, err.Error(), searchErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Подсказки получены успешно", Data: suggestions})
+}
+
+// код ответа для ошибки поиска
+func searchErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrEmptySearch) || errors.Is(err, repository.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	log.Printf("Ошибка поиска продуктов: %v", err)
+	return http.StatusInternalServerError
+}
+
+// проверка длины и числа слов строки поиска из параметра name
+func checkSearchQuery(name, query string) error {
+	if utf8.RuneCountInString(query) > maxSearchRunes {
+		return fmt.Errorf("Параметр %s длиннее %d символов", name, maxSearchRunes)
+	}
+	if len(strings.Fields(query)) > maxSearchWords {
+		return fmt.Errorf("Параметр %s содержит больше %d слов", name, maxSearchWords)
+	}
+	return nil
+}
+
+// фильтр списка продуктов из параметров запроса
+func parseProductFilter(r *http.Request) (repository.ProductFilter, error) {
+	query := r.URL.Query()
+	filter := repository.ProductFilter{
+		Country:            strings.TrimSpace(query.Get("country")),
+		Query:              strings.TrimSpace(query.Get("query")),
+		WithIngredients:    parseIngredientList(query["with_ingredients"]),
+		WithoutIngredients: parseIngredientList(query["without_ingredients"]),
+	}
+	if err := checkSearchQuery("query", filter.Query); err != nil {
+		return filter, err
+	}
+	if value := query.Get("manufacturer_id"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, errors.New("Неверный параметр manufacturer_id")
+		}
+		filter.ManufacturerID = id
+	}
+	var err error
+	if filter.MinVolume, err = parseVolume(query.Get("min_volume")); err != nil {
+		return filter, errors.New("Неверный параметр min_volume")
+	}
+	if filter.MaxVolume, err = parseVolume(query.Get("max_volume")); err != nil {
+		return filter, errors.New("Неверный параметр max_volume")
+	}
+	if filter.MaxVolume > 0 && filter.MinVolume > filter.MaxVolume {
+		return filter, errors.New("Параметр min_volume больше max_volume")
+	}
+	return filter, nil
+}
+
+// Разбор списка ингредиентов фильтра: параметр повторяется или перечисляет id и названия через запятую;
+// название с запятой (1,2-Hexanediol) передается в двойных кавычках
+func parseIngredientList(values []string) []string {
+	var ingredients []string
+	for _, value := range values {
+		var b strings.Builder
+		quoted := false
+		flush := func() {
+			if item := strings.TrimSpace(b.String()); item != "" {
+				ingredients = append(ingredients, item)
+			}
+			b.Reset()
+		}
+		for _, r := range value {
+			switch {
+			case r == '"':
+				quoted = !quoted
+			case r == ',' && !quoted:
+				flush()
+			default:
+				b.WriteRune(r)
+			}
+		}
+		flush()
+	}
+	return ingredients
+}
+
+// разбор границы объема; пустое значение - без ограничения
+func parseVolume(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	volume, err := strconv.ParseFloat(value, 64)
+	if err != nil || volume < 0 {
+		return 0, errors.New("неверный объем")
+	}
+	return volume, nil
 }
 
 // Обработчик получения продукта по id
@@ -172,11 +434,16 @@ func (h *ProductHandler) GetProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Продукт не найден", http.StatusNotFound)
 		return
 	}
+	if h.Views.allow(r, id) {
+		if err := h.Repo.RecordView(id); err != nil {
+			log.Printf("Ошибка учета просмотра продукта %d: %v", id, err)
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.Response{Message: "Продукт получен успешно", Data: product})
 }
 
-// Обработчик обновления продукта
+// Обработчик обновления продукта; без поля structures состав не меняется
 func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -184,18 +451,28 @@ func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Неверный идентификатор продукта", http.StatusBadRequest)
 		return
 	}
+	before := h.snapshot(id)
+	if before == nil {
+		http.Error(w, "Продукт не найден", http.StatusNotFound)
+		return
+	}
 	var product models.Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	product.ID = id
+	if !h.checkProduct(w, &product) {
+		return
+	}
 	if err := h.Repo.Update(&product); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		h.writeProductError(w, err)
 		return
 	}
+	updated := h.snapshot(id)
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityProduct, id, before, updated)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.Response{Message: "Продукт обновлен успешно", Data: product})
+	json.NewEncoder(w).Encode(models.Response{Message: "Продукт обновлен успешно", Data: updated})
 }
 
 // Обработчик удаления продукта
@@ -206,10 +483,41 @@ func (h *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Неверный идентификатор продукта", http.StatusBadRequest)
 		return
 	}
+	before := h.snapshot(id)
+	if before == nil {
+		http.Error(w, "Продукт не найден", http.StatusNotFound)
+		return
+	}
 	if err := h.Repo.Delete(id); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	recordAudit(h.Audit, r, models.AuditDelete, models.AuditEntityProduct, id, before, nil)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(models.Response{Message: "Продукт удален успешно"})
 }
+
+// проверка продукта из JSON; при ошибках полей отправляет 422 и возвращает false
+func (h *ProductHandler) checkProduct(w http.ResponseWriter, product *models.Product) bool {
+	errs, err := h.validate(product)
+	if err != nil {
+		log.Printf("Ошибка проверки продукта: %v", err)
+		http.Error(w, "Ошибка проверки продукта", http.StatusInternalServerError)
+		return false
+	}
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return false
+	}
+	return true
+}
+
+// ответ на ошибку записи продукта; неизвестный ингредиент состава - ошибка поля structures
+func (h *ProductHandler) writeProductError(w http.ResponseWriter, err error) {
+	if errors.Is(err, repository.ErrStructureNotFound) {
+		writeValidationErrors(w, models.ValidationErrors{{Field: "structures", Code: "not_found", Message: err.Error()}})
+		return
+	}
+	log.Printf("Ошибка записи продукта: %v", err)
+	http.Error(w, "Ошибка записи продукта", http.StatusInternalServerError)
+}
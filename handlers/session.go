@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"cosmetics/config"
+	"cosmetics/models"
+	"cosmetics/repository"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Пара токенов, выдаваемая при входе и при обновлении сессии
+type tokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // срок действия токена доступа в секундах
+}
+
+// Создание новой сессии пользователя и выдача пары токенов
+func startSession(sessions *repository.SessionRepository, cfg *config.Config, user *models.User, r *http.Request) (*tokenPair, error) {
+	sessionID, refreshToken, err := sessions.Create(user.ID, cfg.RefreshTokenTTL, clientIP(r, cfg.TrustProxy), r.UserAgent())
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := generateToken(user, sessionID, cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(cfg.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Ротация токена обновления и выдача нового токена доступа
+func refreshSession(users *repository.UserRepository, sessions *repository.SessionRepository, cfg *config.Config, refreshToken string) (*tokenPair, *models.User, error) {
+	userID, sessionID, newRefresh, err := sessions.Rotate(refreshToken, cfg.RefreshTokenTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	//роль берется из БД, чтобы изменения прав вступали в силу при обновлении
+	user, err := users.GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || user.Disabled {
+		sessions.Revoke(sessionID)
+		return nil, nil, repository.ErrRefreshInvalid
+	}
+	accessToken, err := generateToken(user, sessionID, cfg.AccessTokenTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newRefresh,
+		ExpiresIn:    int(cfg.AccessTokenTTL.Seconds()),
+	}, user, nil
+}
+
+// Ошибка обновления, вызванная недействительным токеном (а не сбоем сервера)
+func isRefreshRejected(err error) bool {
+	return errors.Is(err, repository.ErrRefreshInvalid) || errors.Is(err, repository.ErrRefreshReused)
+}
+
+// Установка cookie с токенами для браузерной сессии
+func setSessionCookies(w http.ResponseWriter, pair *tokenPair, cfg *config.Config) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",                            //имя, под которым будет храниться JWT
+		Value:    pair.AccessToken,                   //сам JWT
+		Path:     "/",                                //доступ к cookie всему сайту
+		Expires:  time.Now().Add(cfg.AccessTokenTTL), //срок действия ключа
+		HttpOnly: true,                               //запрещает доступ клиенту
+		Secure:   cfg.CookieSecure,                   //только HTTPS, если включено в настройках
+		SameSite: sameSiteMode(cfg),                  //не отправлять cookie с межсайтовыми POST-запросами
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     "refresh_token",
+		Value:    pair.RefreshToken,
+		Path:     "/",
+		Expires:  time.Now().Add(cfg.RefreshTokenTTL),
+		HttpOnly: true,
+		Secure:   cfg.CookieSecure,
+		SameSite: sameSiteMode(cfg),
+	})
+}
+
+// Удаление cookie сессии
+func clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{"token", "refresh_token"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "", // обнуление значения
+			Path:     "/",
+			Expires:  time.Now().Add(-1 * time.Hour), // устанавка истекшего времени
+			HttpOnly: true,
+		})
+	}
+}
+
+// IP-адрес клиента без порта
+// За обратным прокси (trustProxy) используется первый адрес из X-Forwarded-For
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
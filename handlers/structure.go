@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"cosmetics/repository"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Ингредиенты (таблица structure) и состав продуктов (таблица product_structure)
+type StructureHandler struct {
+	Repo     *repository.StructureRepository
+	Products *repository.ProductRepository
+	Audit    *repository.AuditRepository
+}
+
+// конструктор экземпляра обработчика
+func NewStructureHandler(repo *repository.StructureRepository, products *repository.ProductRepository, audit *repository.AuditRepository) *StructureHandler {
+	return &StructureHandler{Repo: repo, Products: products, Audit: audit}
+}
+
+// тело запроса объединения ингредиентов
+type mergeStructuresRequest struct {
+	SourceIDs []int `json:"source_ids"`
+}
+
+// тело запроса изменения состава продукта
+type productStructuresRequest struct {
+	StructureIDs []int `json:"structure_ids"`
+}
+
+// тело запроса записи состава продукта строкой INCI
+type productINCIRequest struct {
+	INCI   string `json:"inci"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// Разбор тела ингредиента поверх structure: поля, которых нет в теле, сохраняют прежние значения;
+// лишние пробелы в названиях и номерах убираются
+func decodeStructure(r *http.Request, structure *models.Structure) error {
+	if err := json.NewDecoder(r.Body).Decode(structure); err != nil {
+		return err
+	}
+	structure.Name = strings.Join(strings.Fields(structure.Name), " ")
+	structure.INCIName = strings.Join(strings.Fields(structure.INCIName), " ")
+	structure.CASNumber = strings.TrimSpace(structure.CASNumber)
+	structure.ECNumber = strings.TrimSpace(structure.ECNumber)
+	return nil
+}
+
+// ингредиент из параметра id маршрута; при ошибке ответ уже отправлен
+func (h *StructureHandler) structureFromPath(w http.ResponseWriter, r *http.Request) (*models.Structure, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор ингредиента", http.StatusBadRequest)
+		return nil, false
+	}
+	structure, err := h.Repo.GetByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if structure == nil {
+		http.Error(w, "Ингредиент не найден", http.StatusNotFound)
+		return nil, false
+	}
+	return structure, true
+}
+
+// обработчик POST: ингредиент с названием, названием INCI, номерами CAS и EC и синонимами
+func (h *StructureHandler) CreateStructure(w http.ResponseWriter, r *http.Request) {
+	structure := &models.Structure{}
+	if err := decodeStructure(r, structure); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := structure.Validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if err := h.Repo.Create(structure); err != nil {
+		http.Error(w, err.Error(), structureErrorStatus(err))
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditCreate, models.AuditEntityStructure, structure.ID, nil, structure)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиент создан успешно", Data: structure})
+}
+
+// обработчик GETAll: список ингредиентов с числом продуктов, параметр q - поиск по названию,
+// family - ингредиенты семейства (id или название) и его потомков
+func (h *StructureHandler) GetStructures(w http.ResponseWriter, r *http.Request) {
+	structures, err := h.Repo.GetAll(strings.TrimSpace(r.URL.Query().Get("q")), strings.TrimSpace(r.URL.Query().Get("family")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиенты получены успешно", Data: structures})
+}
+
+// обработчик GET: ингредиент по названию, названию INCI или синониму (параметр name)
+func (h *StructureHandler) ResolveStructure(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		http.Error(w, "Не указано название ингредиента name", http.StatusBadRequest)
+		return
+	}
+	structure, err := h.Repo.Resolve(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if structure == nil {
+		http.Error(w, "Ингредиент не найден", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиент получен успешно", Data: structure})
+}
+
+// обработчик GET
+func (h *StructureHandler) GetStructure(w http.ResponseWriter, r *http.Request) {
+	structure, ok := h.structureFromPath(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиент получен успешно", Data: structure})
+}
+
+// обработчик PUT: изменение ингредиента; поля, которых нет в теле (например, synonyms), не меняются
+func (h *StructureHandler) UpdateStructure(w http.ResponseWriter, r *http.Request) {
+	before, ok := h.structureFromPath(w, r)
+	if !ok {
+		return
+	}
+	structure := *before
+	structure.Synonyms = slices.Clone(before.Synonyms)
+	if err := decodeStructure(r, &structure); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	structure.ID = before.ID
+	if errs := structure.Validate(); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if err := h.Repo.Update(&structure); err != nil {
+		http.Error(w, err.Error(), structureErrorStatus(err))
+		return
+	}
+	after, err := h.Repo.GetByID(structure.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityStructure, structure.ID, before, after)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиент обновлен успешно", Data: after})
+}
+
+// обработчик DELETE: ингредиент, входящий в состав продуктов, удаляется только с параметром force=1
+func (h *StructureHandler) DeleteStructure(w http.ResponseWriter, r *http.Request) {
+	structure, ok := h.structureFromPath(w, r)
+	if !ok {
+		return
+	}
+	if structure.ProductCount > 0 && r.URL.Query().Get("force") != "1" {
+		http.Error(w, fmt.Sprintf("Ингредиент входит в состав %d продуктов; для удаления вместе со связями укажите force=1", structure.ProductCount), http.StatusConflict)
+		return
+	}
+	if err := h.Repo.Delete(structure.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditDelete, models.AuditEntityStructure, structure.ID, structure, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиент удален успешно"})
+}
+
+// обработчик POST: объединение ингредиентов source_ids с ингредиентом id;
+// продукты переходят к ингредиенту id, объединенные ингредиенты удаляются
+func (h *StructureHandler) MergeStructures(w http.ResponseWriter, r *http.Request) {
+	target, ok := h.structureFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req mergeStructuresRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.SourceIDs) == 0 {
+		http.Error(w, "Не указаны объединяемые ингредиенты source_ids", http.StatusBadRequest)
+		return
+	}
+	var sources []*models.Structure
+	for _, id := range uniqueIDs(req.SourceIDs) {
+		if id == target.ID {
+			http.Error(w, "Нельзя объединить ингредиент с самим собой", http.StatusBadRequest)
+			return
+		}
+		source, err := h.Repo.GetByID(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if source == nil {
+			http.Error(w, fmt.Sprintf("Ингредиент %d не найден", id), http.StatusNotFound)
+			return
+		}
+		sources = append(sources, source)
+	}
+	sourceIDs := make([]int, len(sources))
+	for i, source := range sources {
+		sourceIDs[i] = source.ID
+	}
+	if err := h.Repo.Merge(target.ID, sourceIDs); err != nil {
+		log.Printf("Ошибка объединения ингредиентов %v с %d: %v", sourceIDs, target.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	merged, err := h.Repo.GetByID(target.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditMerge, models.AuditEntityStructure, target.ID,
+		map[string]interface{}{"target": target, "sources": sources}, merged)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Ингредиенты объединены успешно", Data: merged})
+}
+
+// обработчик GET: состав продукта
+func (h *StructureHandler) GetProductStructures(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.productFromPath(w, r)
+	if !ok {
+		return
+	}
+	structures, err := h.Repo.ForProduct(product.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Состав продукта получен успешно", Data: structures})
+}
+
+// обработчик GET: текст состава для этикетки продукта и ингредиенты в порядке этикетки
+func (h *StructureHandler) GetProductLabel(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.productFromPath(w, r)
+	if !ok {
+		return
+	}
+	label := models.ProductLabel{ProductID: product.ID, Text: models.LabelText(product.Structures), Structures: product.Structures}
+	if label.Structures == nil {
+		label.Structures = []models.Structure{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Состав для этикетки получен успешно", Data: label})
+}
+
+// обработчик PUT: замена всего состава продукта
+func (h *StructureHandler) SetProductStructures(w http.ResponseWriter, r *http.Request) {
+	h.changeProductStructures(w, r, h.Repo.SetForProduct, "Состав продукта заменен успешно")
+}
+
+// обработчик POST: добавление ингредиентов в состав продукта
+func (h *StructureHandler) AddProductStructures(w http.ResponseWriter, r *http.Request) {
+	h.changeProductStructures(w, r, h.Repo.AddToProduct, "Ингредиенты добавлены в состав продукта")
+}
+
+// обработчик DELETE: исключение ингредиента из состава продукта
+func (h *StructureHandler) RemoveProductStructure(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.productFromPath(w, r)
+	if !ok {
+		return
+	}
+	structureID, err := strconv.Atoi(mux.Vars(r)["structure_id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор ингредиента", http.StatusBadRequest)
+		return
+	}
+	removed, err := h.Repo.RemoveFromProduct(product.ID, structureID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Ингредиент не входит в состав продукта", http.StatusNotFound)
+		return
+	}
+	h.writeProductStructures(w, r, product, "Ингредиент исключен из состава продукта")
+}
+
+// общая часть замены и добавления: проверка продукта и ингредиентов, изменение и ответ с новым составом
+func (h *StructureHandler) changeProductStructures(w http.ResponseWriter, r *http.Request, change func(productID int, structureIDs []int) error, message string) {
+	product, ok := h.productFromPath(w, r)
+	if !ok {
+		return
+	}
+	var req productStructuresRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := uniqueIDs(req.StructureIDs)
+	missing, err := h.Repo.Missing(ids)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(missing) > 0 {
+		http.Error(w, fmt.Sprintf("Ингредиенты не найдены: %v", missing), http.StatusBadRequest)
+		return
+	}
+	if err := change(product.ID, ids); err != nil {
+		log.Printf("Ошибка изменения состава продукта ID %d: %v", product.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.writeProductStructures(w, r, product, message)
+}
+
+// Разбор строки состава и поиск ингредиентов в справочнике; false и ответ 400, если ингредиентов нет
+func (h *StructureHandler) parseINCI(w http.ResponseWriter, r *http.Request) (*models.INCIComposition, bool) {
+	var req productINCIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	ingredients := models.ParseINCI(req.INCI)
+	if len(ingredients) == 0 {
+		http.Error(w, "В строке состава не найдено ни одного ингредиента", http.StatusBadRequest)
+		return nil, false
+	}
+	unknown, err := h.Repo.ResolveINCI(ingredients)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	dryRun := req.DryRun || r.URL.Query().Get("dry_run") == "1"
+	return &models.INCIComposition{Ingredients: ingredients, Unknown: unknown, DryRun: dryRun}, true
+}
+
+// обработчик POST: разбор строки состава без записи - ингредиенты по порядку и новые ингредиенты
+func (h *StructureHandler) ParseINCI(w http.ResponseWriter, r *http.Request) {
+	composition, ok := h.parseINCI(w, r)
+	if !ok {
+		return
+	}
+	composition.DryRun = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Строка состава разобрана успешно", Data: composition})
+}
+
+// Обработчик POST: замена состава продукта строкой INCI; ненайденные ингредиенты создаются
+// dry_run (в теле или параметром ?dry_run=1) - только разбор и список новых ингредиентов без записи
+func (h *StructureHandler) SetProductINCI(w http.ResponseWriter, r *http.Request) {
+	product, ok := h.productFromPath(w, r)
+	if !ok {
+		return
+	}
+	composition, ok := h.parseINCI(w, r)
+	if !ok {
+		return
+	}
+	if composition.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.Response{Message: "Строка состава разобрана, состав не изменен", Data: composition})
+		return
+	}
+
+	structures := make([]models.Structure, len(composition.Ingredients))
+	for i, ingredient := range composition.Ingredients {
+		structures[i] = ingredient.Structure()
+	}
+	if err := h.Repo.ReplaceForProduct(product.ID, structures); err != nil {
+		log.Printf("Ошибка записи состава продукта ID %d: %v", product.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	after, err := h.Products.GetByID(product.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityProduct, product.ID, product, after)
+	for i := range composition.Ingredients {
+		composition.Ingredients[i].StructureID = structures[i].ID
+	}
+	composition.Structures = after.Structures
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Состав продукта записан из строки INCI", Data: composition})
+}
+
+// запись изменения состава в журнал аудита и ответ с новым составом продукта
+func (h *StructureHandler) writeProductStructures(w http.ResponseWriter, r *http.Request, before *models.Product, message string) {
+	after, err := h.Products.GetByID(before.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAudit(h.Audit, r, models.AuditUpdate, models.AuditEntityProduct, before.ID, before, after)
+	structures := after.Structures
+	if structures == nil {
+		structures = []models.Structure{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: message, Data: structures})
+}
+
+// продукт из параметра id маршрута; при ошибке ответ уже отправлен
+func (h *StructureHandler) productFromPath(w http.ResponseWriter, r *http.Request) (*models.Product, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор продукта", http.StatusBadRequest)
+		return nil, false
+	}
+	product, err := h.Products.GetByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Продукт не найден", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return product, true
+}
+
+// код ответа для ошибки изменения ингредиента
+func structureErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrStructureExists) {
+		return http.StatusConflict
+	}
+	log.Printf("Ошибка изменения ингредиента: %v", err)
+	return http.StatusInternalServerError
+}
+
+// список id без повторов с сохранением порядка
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	result := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
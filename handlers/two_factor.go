@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"bytes"
+	"cosmetics/models"
+	"cosmetics/repository"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pquerna/otp/totp"
+)
+
+// Двухфакторная аутентификация по RFC 6238 (TOTP):
+// - пользователь подключает приложение-аутентификатор по секрету, otpauth URI или QR-коду,
+// - после проверки пароля вход завершается вторым шагом с кодом из приложения или кодом восстановления,
+// - для ролей из TWO_FACTOR_ROLES подключение обязательно и выполняется прямо при входе
+
+const (
+	totpPeriod        = 30 // длительность временного шага в секундах
+	totpSkew          = 1  // допустимое расхождение часов клиента, в шагах
+	recoveryCodeCount = 10 // число выдаваемых кодов восстановления
+)
+
+// кодировка секрета в otpauth URI
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ошибки двухфакторной аутентификации
+var (
+	errInvalidTOTPCode   = errors.New("Неверный код подтверждения")
+	errTwoFactorEnabled  = errors.New("Двухфакторная аутентификация уже включена")
+	errTwoFactorNotSetUp = errors.New("Двухфакторная аутентификация не подключена")
+	errTwoFactorRequired = errors.New("Для вашей роли двухфакторная аутентификация обязательна")
+)
+
+// данные для подключения приложения-аутентификатора
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+	QRCode string `json:"qr_code"` // PNG с QR-кодом в виде data URI
+}
+
+// ответ на первый шаг входа, если требуется код
+type twoFactorChallenge struct {
+	MFARequired bool            `json:"mfa_required"`
+	MFAToken    string          `json:"mfa_token"`
+	Enrollment  *totpEnrollment `json:"enrollment,omitempty"` // заполняется, если 2FA нужно подключить при входе
+}
+
+// ответ на второй шаг входа
+type twoFactorLogin struct {
+	*tokenPair
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // выдаются один раз при подключении 2FA
+}
+
+// тело запроса второго шага входа
+type twoFactorLoginRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// тело запросов управления собственной 2FA
+type twoFactorRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// код ответа для ошибки двухфакторной аутентификации
+func twoFactorErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrChallengeInvalid), errors.Is(err, errInvalidTOTPCode):
+		return http.StatusUnauthorized
+	case errors.Is(err, errTwoFactorEnabled):
+		return http.StatusConflict
+	case errors.Is(err, errTwoFactorNotSetUp):
+		return http.StatusBadRequest
+	case errors.Is(err, errTwoFactorRequired):
+		return http.StatusForbidden
+	}
+	log.Printf("Ошибка двухфакторной аутентификации: %v", err)
+	return http.StatusInternalServerError
+}
+
+// обязательна ли 2FA для роли по настройкам сервера
+func (h *UserHandler) requiresTwoFactor(role models.Role) bool {
+	return slices.Contains(h.Config.TwoFactorRoles, string(role))
+}
+
+// данные подключения по секрету; пустой секрет генерируется заново
+func (h *UserHandler) enrollment(user *models.User, secret string) (*totpEnrollment, error) {
+	opts := totp.GenerateOpts{Issuer: h.Config.TOTPIssuer, AccountName: user.UserName, Period: totpPeriod}
+	if secret != "" {
+		raw, err := totpEncoding.DecodeString(secret)
+		if err != nil {
+			return nil, err
+		}
+		opts.Secret = raw
+	}
+	key, err := totp.Generate(opts)
+	if err != nil {
+		return nil, err
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &totpEnrollment{
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// выдача нового секрета для подключения 2FA; до подтверждения кодом он не действует
+func (h *UserHandler) startEnrollment(user *models.User) (*totpEnrollment, error) {
+	enrollment, err := h.enrollment(user, "")
+	if err != nil {
+		return nil, err
+	}
+	ok, err := h.TwoFactor.SetPendingSecret(user.ID, enrollment.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errTwoFactorEnabled
+	}
+	return enrollment, nil
+}
+
+// проверка кода TOTP с допуском totpSkew шагов; возвращает номер принятого шага
+// коды из уже использованных шагов отклоняются, чтобы перехваченный код нельзя было повторить
+func (h *UserHandler) verifyTOTP(userID int, code string) (int64, error) {
+	secret, lastStep, err := h.TwoFactor.GetSecret(userID)
+	if err != nil {
+		return 0, err
+	}
+	if secret == "" {
+		return 0, errTwoFactorNotSetUp
+	}
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	now := time.Now()
+	for i := -totpSkew; i <= totpSkew; i++ {
+		t := now.Add(time.Duration(i*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{Period: totpPeriod})
+		if err != nil {
+			return 0, err
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expected)) == 1 {
+			step := t.Unix() / totpPeriod
+			if step <= lastStep {
+				return 0, errInvalidTOTPCode
+			}
+			return step, nil
+		}
+	}
+	return 0, errInvalidTOTPCode
+}
+
+// новый набор кодов восстановления вида xxxx-xxxx
+func newRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes, nil
+}
+
+// приведение кода восстановления к виду, в котором хранится его хеш
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+// проверка второго фактора: кода TOTP или кода восстановления
+func (h *UserHandler) checkSecondFactor(user *models.User, code, recoveryCode string) error {
+	if recoveryCode != "" {
+		ok, err := h.TwoFactor.UseRecoveryCode(user.ID, normalizeRecoveryCode(recoveryCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInvalidTOTPCode
+		}
+		return nil
+	}
+	step, err := h.verifyTOTP(user.ID, code)
+	if err != nil {
+		return err
+	}
+	ok, err := h.TwoFactor.MarkStep(user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errInvalidTOTPCode
+	}
+	return nil
+}
+
+// подтверждение подключения 2FA первым кодом; возвращает коды восстановления
+func (h *UserHandler) confirmEnrollment(user *models.User, code string) ([]string, error) {
+	step, err := h.verifyTOTP(user.ID, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.TwoFactor.Enable(user.ID, step, codes); err != nil {
+		return nil, err
+	}
+	user.TwoFactorEnabled = true
+	return codes, nil
+}
+
+// начало второго шага входа после проверки пароля; nil, если 2FA не требуется
+func (h *UserHandler) beginTwoFactor(user *models.User) (*twoFactorChallenge, error) {
+	if !user.TwoFactorEnabled && !h.requiresTwoFactor(user.Role) {
+		return nil, nil
+	}
+	token, err := h.TwoFactor.CreateChallenge(user.ID, h.Config.TwoFactorChallengeTTL)
+	if err != nil {
+		return nil, err
+	}
+	challenge := &twoFactorChallenge{MFARequired: true, MFAToken: token}
+	//роль требует 2FA, а приложение еще не подключено: подключение выполняется при входе
+	if !user.TwoFactorEnabled {
+		if challenge.Enrollment, err = h.startEnrollment(user); err != nil {
+			return nil, err
+		}
+	}
+	return challenge, nil
+}
+
+// завершение второго шага входа; при подключении 2FA возвращает коды восстановления
+// неверный код учитывается как неудачная попытка входа
+func (h *UserHandler) completeTwoFactor(token, code, recoveryCode, ip string) (*models.User, []string, error) {
+	userID, err := h.TwoFactor.GetChallenge(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := h.Repo.GetUserByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user == nil || user.Disabled {
+		return nil, nil, repository.ErrChallengeInvalid
+	}
+
+	var recoveryCodes []string
+	if user.TwoFactorEnabled {
+		err = h.checkSecondFactor(user, code, recoveryCode)
+	} else {
+		recoveryCodes, err = h.confirmEnrollment(user, code)
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidTOTPCode) {
+			h.TwoFactor.FailChallenge(token)
+			h.recordLoginFailure(user.UserName, ip, user)
+		}
+		return nil, nil, err
+	}
+	if err := h.TwoFactor.CompleteChallenge(token); err != nil {
+		return nil, nil, err
+	}
+	h.recordLoginSuccess(user, ip)
+	return user, recoveryCodes, nil
+}
+
+// обработка POST-запроса второго шага входа: mfa_token из первого шага и код из приложения
+// (или код восстановления); при подключении 2FA в ответе также коды восстановления
+func (h *UserHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	user, recoveryCodes, err := h.completeTwoFactor(req.MFAToken, req.Code, req.RecoveryCode, clientIP(r, h.Config.TrustProxy))
+	if err != nil {
+		if status := twoFactorErrorStatus(err); status == http.StatusUnauthorized {
+			handleUnauthorized(w, err.Error())
+		} else {
+			http.Error(w, err.Error(), status)
+		}
+		return
+	}
+	pair, err := startSession(h.Sessions, h.Config, user, r)
+	if err != nil {
+		log.Printf("Ошибка генерации токена: %v", err)
+		http.Error(w, "Ошибка генерации токена", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{
+		Message: "Авторизация прошла успешно",
+		Data:    twoFactorLogin{tokenPair: pair, RecoveryCodes: recoveryCodes},
+	})
+}
+
+// текущий пользователь по claims из контекста
+func (h *UserHandler) currentUser(w http.ResponseWriter, r *http.Request) *models.User {
+	user, err := h.Repo.GetUserByID(claimsFromContext(r).UserID)
+	if err != nil {
+		log.Printf("Ошибка при поиске пользователя: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return nil
+	}
+	if user == nil {
+		handleUnauthorized(w, "Пользователь не найден")
+		return nil
+	}
+	return user
+}
+
+// обработка POST-запроса выдачи секрета для подключения 2FA
+func (h *UserHandler) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	enrollment, err := h.startEnrollment(user)
+	if err != nil {
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{
+		Message: "Добавьте секрет в приложение-аутентификатор и подтвердите подключение кодом",
+		Data:    enrollment,
+	})
+}
+
+// обработка POST-запроса подтверждения подключения 2FA кодом из приложения
+func (h *UserHandler) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if user.TwoFactorEnabled {
+		http.Error(w, errTwoFactorEnabled.Error(), http.StatusConflict)
+		return
+	}
+	codes, err := h.confirmEnrollment(user, req.Code)
+	if err != nil {
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{
+		Message: "Двухфакторная аутентификация включена. Сохраните коды восстановления",
+		Data:    map[string][]string{"recovery_codes": codes},
+	})
+}
+
+// обработка POST-запроса отключения 2FA: требуется пароль и код (или код восстановления)
+func (h *UserHandler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if !user.CheckPassword(req.Password) {
+		handleUnauthorized(w, "Неверный текущий пароль")
+		return
+	}
+	if h.requiresTwoFactor(user.Role) {
+		http.Error(w, errTwoFactorRequired.Error(), http.StatusForbidden)
+		return
+	}
+	if !user.TwoFactorEnabled {
+		http.Error(w, errTwoFactorNotSetUp.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkSecondFactor(user, req.Code, req.RecoveryCode); err != nil {
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+	if err := h.TwoFactor.Disable(user.ID); err != nil {
+		log.Printf("Ошибка отключения 2FA: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Двухфакторная аутентификация отключена"})
+}
+
+// обработка POST-запроса выдачи нового набора кодов восстановления (требуется код из приложения)
+func (h *UserHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+		return
+	}
+	user := h.currentUser(w, r)
+	if user == nil {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		http.Error(w, errTwoFactorNotSetUp.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.checkSecondFactor(user, req.Code, ""); err != nil {
+		http.Error(w, err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+	codes, err := newRecoveryCodes()
+	if err == nil {
+		err = h.TwoFactor.ReplaceRecoveryCodes(user.ID, codes)
+	}
+	if err != nil {
+		log.Printf("Ошибка выдачи кодов восстановления: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{
+		Message: "Выданы новые коды восстановления, прежние больше не действуют",
+		Data:    map[string][]string{"recovery_codes": codes},
+	})
+}
+
+// обработка POST-запроса администратора: сброс 2FA пользователя, потерявшего приложение
+// если роль требует 2FA, пользователь подключит его заново при следующем входе
+func (h *UserHandler) ResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	if err := h.resetTwoFactor(r, id); err != nil {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Двухфакторная аутентификация пользователя сброшена"})
+}
+
+// сброс 2FA пользователя с завершением его сессий
+func (h *UserHandler) resetTwoFactor(r *http.Request, id int) error {
+	user, err := h.Repo.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errUserNotFound
+	}
+	if err := h.TwoFactor.Disable(id); err != nil {
+		return err
+	}
+	if err := h.Sessions.RevokeAllForUser(id); err != nil {
+		return err
+	}
+	before := *user
+	user.TwoFactorEnabled = false
+	recordAudit(h.Audit, r, models.AuditTwoFactorReset, models.AuditEntityUser, id, before, user)
+	return nil
+}
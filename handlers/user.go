@@ -1,26 +1,57 @@
 package handlers
 
 import (
+	"cosmetics/config"
 	"cosmetics/models"
 	"cosmetics/repository"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
 )
 
 // создание структуры-зависимости для взаимодействия с репозиторием
 type UserHandler struct {
-	Repo *repository.UserRepository
+	Repo      *repository.UserRepository
+	Invites   *repository.InviteRepository
+	Sessions  *repository.SessionRepository
+	Attempts  *repository.LoginAttemptRepository
+	Resets    *repository.PasswordResetRepository
+	TwoFactor *repository.TwoFactorRepository
+	Audit     *repository.AuditRepository
+	Config    *config.Config
+}
+
+// конструктор для создания экземпляра с внедренными репозиториями и настройками
+func NewUserHandler(repo *repository.UserRepository, invites *repository.InviteRepository, sessions *repository.SessionRepository, attempts *repository.LoginAttemptRepository, resets *repository.PasswordResetRepository, twoFactor *repository.TwoFactorRepository, audit *repository.AuditRepository, cfg *config.Config) *UserHandler {
+	return &UserHandler{Repo: repo, Invites: invites, Sessions: sessions, Attempts: attempts, Resets: resets, TwoFactor: twoFactor, Audit: audit, Config: cfg}
 }
 
-// конструктор для создания экземпляра с внедренным репозиторием
-func NewUserHandler(repo *repository.UserRepository) *UserHandler {
-	return &UserHandler{Repo: repo}
+// тело запроса входа
+type loginRequest struct {
+	UserName string `json:"username"`
+	Password string `json:"password"`
 }
 
+// тело запроса регистрации
+type registerRequest struct {
+	UserName   string `json:"username"`
+	Password   string `json:"password"`
+	InviteCode string `json:"invite_code"`
+}
+
+// ошибки регистрации, зависящие от режима
+var (
+	errRegistrationClosed = errors.New("Регистрация закрыта")
+	errInviteRequired     = errors.New("Для регистрации требуется код приглашения")
+)
+
 // обработка POST-запроса к маршруту входа
 func (h *UserHandler) LoginUser(w http.ResponseWriter, r *http.Request) {
-	var reqUser models.User
+	var reqUser loginRequest
 	//получение тела запроса и декодирование в структуру(username пользователя и пароль)
 	if err := json.NewDecoder(r.Body).Decode(&reqUser); err != nil {
 		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
@@ -34,18 +65,48 @@ func (h *UserHandler) LoginUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 		return
 	}
-	//обработка ошибки данных пользователя
-	if user == nil {
-		handleUnauthorized(w, "Неверное имя пользователя или пароль")
+	//проверка ограничений защиты от перебора
+	ip := clientIP(r, h.Config.TrustProxy)
+	block, err := h.checkLoginAllowed(reqUser.UserName, ip, user)
+	if err != nil {
+		log.Printf("Ошибка проверки попыток входа: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
 		return
 	}
-	//обработка при неверном пароле
-	if !user.CheckPassword(reqUser.Password) {
+	if block != nil {
+		writeLoginBlock(w, block)
+		return
+	}
+	//обработка ошибки данных пользователя и неверного пароля
+	if user == nil || !user.CheckPassword(reqUser.Password) {
+		h.recordLoginFailure(reqUser.UserName, ip, user)
 		handleUnauthorized(w, "Неверное имя пользователя или пароль")
 		return
 	}
-	//генерация токена
-	tokenString, err := generateToken(user.UserName)
+	//отключенная учетная запись не может войти
+	if user.Disabled {
+		handleForbidden(w, "Учетная запись отключена")
+		return
+	}
+	h.upgradePasswordHash(user, reqUser.Password)
+	//при включенной 2FA вход завершается вторым шагом через /api/login/2fa
+	challenge, err := h.beginTwoFactor(user)
+	if err != nil {
+		log.Printf("Ошибка начала второго шага входа: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if challenge != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.Response{
+			Message: "Введите код из приложения-аутентификатора",
+			Data:    challenge,
+		})
+		return
+	}
+	h.recordLoginSuccess(user, ip)
+	//создание сессии и генерация пары токенов
+	pair, err := startSession(h.Sessions, h.Config, user, r)
 	//обработка ошибки при генерации токена
 	if err != nil {
 		log.Printf("Ошибка генерации токена: %v", err)
@@ -57,34 +118,113 @@ func (h *UserHandler) LoginUser(w http.ResponseWriter, r *http.Request) {
 	//отправка json
 	json.NewEncoder(w).Encode(models.Response{
 		Message: "Авторизация прошла успешно",
-		Data: map[string]string{
-			"token": tokenString,
-		},
+		Data:    pair,
+	})
+}
+
+// обработка POST-запроса обновления токена доступа
+// токен обновления передается в теле {"refresh_token": "..."} или в cookie refresh_token
+func (h *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	fromCookie := false
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		if cookie, err := r.Cookie("refresh_token"); err == nil {
+			req.RefreshToken = cookie.Value
+			fromCookie = true
+		}
+	}
+	if req.RefreshToken == "" {
+		handleUnauthorized(w, "Токен обновления не передан")
+		return
+	}
+	//ротация токена обновления
+	pair, _, err := refreshSession(h.Repo, h.Sessions, h.Config, req.RefreshToken)
+	if err != nil {
+		if isRefreshRejected(err) {
+			handleUnauthorized(w, err.Error())
+			return
+		}
+		log.Printf("Ошибка обновления токена: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	//браузерная сессия получает новые cookie
+	if fromCookie {
+		setSessionCookies(w, pair, h.Config)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{
+		Message: "Токен обновлен успешно",
+		Data:    pair,
 	})
 }
 
+// обработка POST-запроса выхода: отзыв текущего токена и его сессии
+func (h *UserHandler) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r)
+	if err := h.revokeCurrent(claims); err != nil {
+		log.Printf("Ошибка отзыва сессии: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	clearSessionCookies(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Выход выполнен успешно"})
+}
+
+// обработка POST-запроса выхода на всех устройствах: отзыв всех сессий пользователя
+func (h *UserHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromContext(r)
+	if err := h.revokeCurrent(claims); err != nil {
+		log.Printf("Ошибка отзыва сессии: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	if err := h.Sessions.RevokeAllForUser(claims.UserID); err != nil {
+		log.Printf("Ошибка отзыва сессий пользователя: %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
+	}
+	clearSessionCookies(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Все сессии пользователя завершены"})
+}
+
+// отзыв текущего JWT и сессии, к которой он привязан
+func (h *UserHandler) revokeCurrent(claims *Claims) error {
+	if err := h.Sessions.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+	return h.Sessions.Revoke(claims.SessionID)
+}
+
 func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
-	//получение тела запроса и декодирование в структуру(username пользователя и пароль)
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	var req registerRequest
+	//получение тела запроса и декодирование в структуру(username пользователя, пароль и код приглашения)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Неверный формат запроса", http.StatusBadRequest)
 		return
 	}
 	//обработка и проверка на пустоту полей
-	if user.UserName == "" || user.Password == "" {
+	if req.UserName == "" || req.Password == "" {
 		http.Error(w, "Имя пользователя и пароль обязательны", http.StatusBadRequest)
 		return
 	}
-	//обработка хеширования пароля
-	if err := user.SetPassword(user.Password); err != nil {
-		log.Printf("Ошибка хеширования пароля: %v", err)
-		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
-		return
-	}
-	//создание пользователя и обработка ошибки создания записи в бд
-	if err := h.Repo.CreateUser(&user); err != nil {
-		log.Printf("Ошибка создания пользователя в БД: %v", err)
-		http.Error(w, "Ошибка регистрации пользователя", http.StatusInternalServerError)
+	//создание пользователя с учетом режима регистрации
+	if _, err := h.register(req.UserName, req.Password, req.InviteCode); err != nil {
+		if errs, ok := models.AsValidationErrors(err); ok {
+			writeValidationErrors(w, errs)
+			return
+		}
+		http.Error(w, err.Error(), registerErrorStatus(err))
 		return
 	}
 	//установка заголовка
@@ -95,6 +235,124 @@ func (h *UserHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// обработка POST-запроса разблокировки учетной записи администратором
+func (h *UserHandler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	user, err := h.unlockUser(r, id)
+	if err != nil {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	log.Printf("Учетная запись '%s' разблокирована", user.UserName)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Учетная запись разблокирована"})
+}
+
+// обработка GET-запроса журнала попыток входа (фильтры username, ip, limit)
+func (h *UserHandler) GetLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	attempts, err := h.Attempts.List(query.Get("username"), query.Get("ip"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Журнал попыток входа получен успешно", Data: attempts})
+}
+
+// создание пользователя с учетом режима регистрации (общая часть JSON и HTML-формы)
+func (h *UserHandler) register(username, password, inviteCode string) (*models.User, error) {
+	mode := h.Config.RegistrationMode
+	if mode == config.RegistrationClosed {
+		return nil, errRegistrationClosed
+	}
+	if mode == config.RegistrationInviteOnly && inviteCode == "" {
+		return nil, errInviteRequired
+	}
+
+	//проверка логина и его уникальности
+	user := &models.User{UserName: username}
+	errs := user.Validate()
+	if !errs.Has("username") {
+		existing, err := h.Repo.GetUserByUsername(username)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			errs = errs.Add("username", "taken", "Логин уже занят")
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	//проверка пароля на соответствие политике
+	if err := PasswordPolicy(h.Config).Validate(password); err != nil {
+		return nil, err
+	}
+
+	//обработка хеширования пароля
+	if err := user.SetPassword(password); err != nil {
+		log.Printf("Ошибка хеширования пароля: %v", err)
+		return nil, errors.New("Внутренняя ошибка сервера")
+	}
+
+	//при наличии кода роль берется из приглашения, иначе - только чтение
+	if inviteCode != "" {
+		if err := h.Invites.RegisterWithInvite(inviteCode, user); err != nil {
+			if errors.Is(err, repository.ErrInviteInvalid) {
+				return nil, err
+			}
+			log.Printf("Ошибка регистрации по приглашению: %v", err)
+			return nil, errors.New("Ошибка регистрации пользователя")
+		}
+		return user, nil
+	}
+
+	user.Role = models.RoleViewer
+	//создание пользователя и обработка ошибки создания записи в бд
+	if err := h.Repo.CreateUser(user); err != nil {
+		log.Printf("Ошибка создания пользователя в БД: %v", err)
+		return nil, errors.New("Ошибка регистрации пользователя")
+	}
+	return user, nil
+}
+
+// код ответа для ошибки регистрации
+func registerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errRegistrationClosed), errors.Is(err, repository.ErrInviteInvalid):
+		return http.StatusForbidden
+	case errors.Is(err, errInviteRequired), models.IsPasswordPolicyError(err):
+		return http.StatusBadRequest
+	}
+	if _, ok := models.AsValidationErrors(err); ok {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// функция для ответа с ошибками проверки полей
+func writeValidationErrors(w http.ResponseWriter, errs models.ValidationErrors) {
+	w.Header().Set("Content-Type", "application/json")
+	//отправка json-ответа с кодом 422 и списком ошибок по полям
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(models.ValidationErrorResponse{
+		Error:   "Unprocessable Entity",
+		Message: "Данные не прошли проверку",
+		Errors:  errs,
+	})
+}
+
 // функция для обработки неавторизованных пользователей
 func handleUnauthorized(w http.ResponseWriter, message string) {
 	//установка заголовка
@@ -106,3 +364,15 @@ func handleUnauthorized(w http.ResponseWriter, message string) {
 		Message: message,
 	})
 }
+
+// функция для обработки запросов без необходимых прав
+func handleForbidden(w http.ResponseWriter, message string) {
+	//установка заголовка
+	w.Header().Set("Content-Type", "application/json")
+	//отправка json-ответа с кодом 403
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(models.ErrorResponse{
+		Error:   "Forbidden",
+		Message: message,
+	})
+}
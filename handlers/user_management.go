@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"cosmetics/models"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//Управление пользователями администратором: список, поиск, роли, отключение и удаление
+
+// ошибки операций над пользователями
+var (
+	errUserNotFound = errors.New("Пользователь не найден")
+	errUnknownRole  = errors.New("Неизвестная роль")
+	errSelfChange   = errors.New("Нельзя изменить роль, отключить или удалить собственную учетную запись")
+	errLastAdmin    = errors.New("Нельзя лишить системы последнего активного администратора")
+)
+
+// код ответа для ошибки операции над пользователем
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errUnknownRole), errors.Is(err, errSelfChange):
+		return http.StatusBadRequest
+	case errors.Is(err, errLastAdmin):
+		return http.StatusConflict
+	}
+	log.Printf("Ошибка управления пользователем: %v", err)
+	return http.StatusInternalServerError
+}
+
+// загрузка изменяемого пользователя с проверкой, что это не сам администратор
+func (h *UserHandler) targetUser(r *http.Request, id int) (*models.User, error) {
+	if claims := claimsFromContext(r); claims != nil && claims.UserID == id {
+		return nil, errSelfChange
+	}
+	user, err := h.Repo.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errUserNotFound
+	}
+	return user, nil
+}
+
+// проверка, что после операции в системе останется активный администратор
+func (h *UserHandler) ensureOtherAdmin(user *models.User) error {
+	if user.Role != models.RoleAdmin || user.Disabled {
+		return nil
+	}
+	count, err := h.Repo.CountActiveAdmins(user.ID)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+// изменение роли пользователя
+func (h *UserHandler) changeRole(r *http.Request, id int, role models.Role) (*models.User, error) {
+	if !role.Valid() {
+		return nil, errUnknownRole
+	}
+	user, err := h.targetUser(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if role != models.RoleAdmin {
+		if err := h.ensureOtherAdmin(user); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.Repo.UpdateRole(id, role); err != nil {
+		return nil, err
+	}
+	before := *user
+	user.Role = role
+	recordAudit(h.Audit, r, models.AuditRoleChange, models.AuditEntityUser, id, before, user)
+	return user, nil
+}
+
+// отключение или включение пользователя; при отключении все его сессии завершаются
+func (h *UserHandler) setDisabled(r *http.Request, id int, disabled bool) (*models.User, error) {
+	user, err := h.targetUser(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		if err := h.ensureOtherAdmin(user); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.Repo.SetDisabled(id, disabled); err != nil {
+		return nil, err
+	}
+	if disabled {
+		if err := h.Sessions.RevokeAllForUser(id); err != nil {
+			return nil, err
+		}
+	}
+	before := *user
+	user.Disabled = disabled
+	action := models.AuditEnable
+	if disabled {
+		action = models.AuditDisable
+	}
+	recordAudit(h.Audit, r, action, models.AuditEntityUser, id, before, user)
+	return user, nil
+}
+
+// удаление пользователя
+func (h *UserHandler) deleteUser(r *http.Request, id int) (*models.User, error) {
+	user, err := h.targetUser(r, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ensureOtherAdmin(user); err != nil {
+		return nil, err
+	}
+	if err := h.Repo.Delete(id); err != nil {
+		return nil, err
+	}
+	recordAudit(h.Audit, r, models.AuditDelete, models.AuditEntityUser, id, user, nil)
+	return user, nil
+}
+
+// снятие блокировки входа после неудачных попыток
+func (h *UserHandler) unlockUser(r *http.Request, id int) (*models.User, error) {
+	user, err := h.Repo.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errUserNotFound
+	}
+	if err := h.Repo.ResetLoginFailures(id); err != nil {
+		return nil, err
+	}
+	before := *user
+	user.FailedLogins, user.LockedUntil = 0, nil
+	recordAudit(h.Audit, r, models.AuditUnlock, models.AuditEntityUser, id, before, user)
+	return user, nil
+}
+
+// обработчик GETAll: список пользователей, параметр q - поиск по логину
+func (h *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.Repo.GetAll(strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Пользователи получены успешно", Data: users})
+}
+
+// обработчик GET
+func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Repo.GetUserByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil {
+		http.Error(w, "Пользователь не найден", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Пользователь получен успешно", Data: user})
+}
+
+// обработчик PUT: изменение роли, тело {"role": "editor"}
+func (h *UserHandler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Role models.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.changeRole(r, id, req.Role)
+	if err != nil {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Роль пользователя изменена", Data: user})
+}
+
+// обработчик POST: отключение пользователя
+func (h *UserHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	h.writeDisabled(w, r, true, "Пользователь отключен")
+}
+
+// обработчик POST: включение пользователя
+func (h *UserHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	h.writeDisabled(w, r, false, "Пользователь включен")
+}
+
+// общая часть отключения и включения
+func (h *UserHandler) writeDisabled(w http.ResponseWriter, r *http.Request, disabled bool, message string) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	user, err := h.setDisabled(r, id, disabled)
+	if err != nil {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: message, Data: user})
+}
+
+// обработчик DELETE
+func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.deleteUser(r, id); err != nil {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Response{Message: "Пользователь удален успешно"})
+}
+
+// Обработка форм управления пользователями из админ-панели и редирект обратно
+// Поле action: role (с полем role), disable, enable, unlock, reset-2fa, delete
+func HandleUserFormSubmission(h *UserHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Неверный идентификатор пользователя", http.StatusBadRequest)
+			return
+		}
+		if !can(r, models.PermUsersManage) {
+			http.Error(w, "Недостаточно прав для выполнения операции", http.StatusForbidden)
+			return
+		}
+
+		r.ParseForm()
+		switch r.PostFormValue("action") {
+		case "role":
+			_, err = h.changeRole(r, id, models.Role(r.PostFormValue("role")))
+		case "disable":
+			_, err = h.setDisabled(r, id, true)
+		case "enable":
+			_, err = h.setDisabled(r, id, false)
+		case "unlock":
+			_, err = h.unlockUser(r, id)
+		case "reset-2fa":
+			err = h.resetTwoFactor(r, id)
+		case "delete":
+			_, err = h.deleteUser(r, id)
+		default:
+			http.Error(w, "Неизвестное действие", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), userErrorStatus(err))
+			return
+		}
+		log.Printf("Действие '%s' над пользователем ID %d выполнено. Редирект на /admin", r.PostFormValue("action"), id)
+		http.Redirect(w, r, "/admin#users", http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// при таком числе записей устаревшие удаляются из памяти; если устаревших нет, память очищается целиком
+const viewCounterSweep = 10000
+
+// Учет просмотров продукта для популярности в подсказках поиска без авторизации:
+// - повторный просмотр того же продукта с того же IP в течение window не считается,
+// - запросы с недействительным заголовком Authorization не считаются
+type viewCounter struct {
+	mu         sync.Mutex
+	window     time.Duration
+	trustProxy bool
+	seen       map[string]time.Time // IP и id продукта -> время учтенного просмотра
+}
+
+// конструктор счетчика просмотров
+func newViewCounter(window time.Duration, trustProxy bool) *viewCounter {
+	return &viewCounter{window: window, trustProxy: trustProxy, seen: map[string]time.Time{}}
+}
+
+// true, если просмотр продукта id запросом r нужно учесть
+func (c *viewCounter) allow(r *http.Request, id int) bool {
+	if r.Header.Get("Authorization") != "" {
+		token, source := extractToken(r)
+		if source != authSourceBearer {
+			return false
+		}
+		if _, err := parseToken(token); err != nil {
+			return false
+		}
+	}
+
+	key := clientIP(r, c.trustProxy) + " " + strconv.Itoa(id)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.seen[key]; ok && now.Sub(last) < c.window {
+		return false
+	}
+	if len(c.seen) >= viewCounterSweep {
+		for k, last := range c.seen {
+			if now.Sub(last) >= c.window {
+				delete(c.seen, k)
+			}
+		}
+		if len(c.seen) >= viewCounterSweep {
+			c.seen = map[string]time.Time{}
+		}
+	}
+	c.seen[key] = now
+	return true
+}
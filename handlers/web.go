@@ -1,16 +1,16 @@
 package handlers
 
 import (
+	"bytes"
 	"cosmetics/models"
 	"cosmetics/repository"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-	"time"
-
-	jwt "github.com/golang-jwt/jwt/v5"
 )
 
 // структура данных для рендеринга главной и админ-страниц
@@ -19,9 +19,28 @@ type WelcomePageData struct {
 	Manufacturers          []models.Manufacturer
 	SelectedManufacturerID int
 	SearchQuery            string
+	WithIngredients        string // значения полей фильтра по составу, как введены
+	WithoutIngredients     string
 	IsAuthenticated        bool
+	CanWrite               bool // право создания и изменения продуктов
+	CanDelete              bool // право удаления продуктов
+	Users                  []models.User
+	UserQuery              string
+	CanManageUsers         bool // право управления пользователями
+	CurrentUserID          int
+	CSRFToken              string // токен для скрытого поля csrf_token изменяющих форм
+	CanViewAudit           bool   // право просмотра журнала аудита
+	AuditEntries           []models.AuditEntry
+	AuditFilter            repository.AuditFilter
+	ProductForm            *ProductForm // форма продукта с ошибками проверки
+	Pagination             *models.Pagination
+	Snippets               map[int]template.HTML // фрагменты текста с совпадениями поиска по id продукта
+	DidYouMean             string                // исправленная строка поиска, по которой найдены продукты
 }
 
+// число продуктов на странице каталога
+const indexPageLimit = 9
+
 // обработчик главной страницы
 func WebHandler(productRepo *repository.ProductRepository, manufacturerRepo *repository.ManufacturerRepository) http.HandlerFunc {
 	// предварительная загрузка и парсинг шаблонов при старте приложения
@@ -32,18 +51,40 @@ func WebHandler(productRepo *repository.ProductRepository, manufacturerRepo *rep
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// извлечение идентификатора производителя из параметров URL для фильтрации
-		manufacturerIDStr := r.URL.Query().Get("manufacturer_id")
-		// преобразование идентификатора производителя в int
-		manufacturerID, _ := strconv.Atoi(manufacturerIDStr)
-		// извлечение и очистка строки поиска из параметров URL
-		searchQuery := strings.TrimSpace(r.URL.Query().Get("query"))
-
-		// получение отфильтрованных продуктов из репозитория
-		products, err := productRepo.GetProductsSearch(manufacturerID, searchQuery)
+		// параметры страницы и фильтры (производитель, строка поиска и др.) из URL, как в GET /api/products
+		page, err := parsePage(r, indexPageLimit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter, err := parseProductFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// получение страницы отфильтрованных продуктов из репозитория;
+		// со строкой поиска - полнотекстовый поиск с фрагментами текста по релевантности
+		// и исправлением опечаток, если по строке ничего не найдено
+		var products []models.Product
+		var snippets map[int]template.HTML
+		var info repository.PageInfo
+		var didYouMean string
+		if filter.Query != "" {
+			var results []models.ProductSearchResult
+			results, info, didYouMean, err = productRepo.SearchCorrected(filter.Query, filter, page)
+			snippets = make(map[int]template.HTML, len(results))
+			for _, res := range results {
+				products = append(products, res.Product)
+				// фрагмент уже экранирован репозиторием, кроме тегов <mark>
+				snippets[res.ID] = template.HTML(res.Snippet)
+			}
+		} else {
+			products, info, err = productRepo.List(filter, page)
+		}
 		// обработка ошибки получения данных о продуктах
 		if err != nil {
-			http.Error(w, "Ошибка получения данных о продуктах", http.StatusInternalServerError)
+			http.Error(w, "Ошибка получения данных о продуктах", pageErrorStatus(err))
 			return
 		}
 
@@ -59,26 +100,34 @@ func WebHandler(productRepo *repository.ProductRepository, manufacturerRepo *rep
 		// Проверка авторизации по JWT из cookie
 		isAuthenticated := false
 
+		csrf := ""
+
 		// попытка получить cookie "token"
 		if cookie, err := r.Cookie("token"); err == nil {
-			claims := &Claims{}
-			// парсинг токена с проверкой подписи и извлечением клеймов
-			token, err := jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
-				return jwtKey, nil
-			})
-			// проверка: если нет ошибки парсинга и токен валиден (срок действия, подпись)
-			if err == nil && token.Valid {
+			// парсинг токена с проверкой подписи и срока действия
+			if claims, err := parseToken(cookie.Value); err == nil {
 				isAuthenticated = true
+				csrf = csrfToken(claims.SessionID)
 			}
 		}
+		// истекший токен доступа будет продлен по cookie refresh_token при переходе в админ-панель
+		if cookie, err := r.Cookie("refresh_token"); err == nil && cookie.Value != "" {
+			isAuthenticated = true
+		}
 
 		// сбор всех данных в структуру для шаблона
 		data := WelcomePageData{
 			Products:               products,
 			Manufacturers:          manufacturers,
-			SelectedManufacturerID: manufacturerID,
-			SearchQuery:            searchQuery,
+			SelectedManufacturerID: filter.ManufacturerID,
+			SearchQuery:            filter.Query,
+			WithIngredients:        r.URL.Query().Get("with_ingredients"),
+			WithoutIngredients:     r.URL.Query().Get("without_ingredients"),
+			Pagination:             pagination(r, page, info),
+			Snippets:               snippets,
+			DidYouMean:             didYouMean,
 			IsAuthenticated:        isAuthenticated, // флаг для условного рендеринга
+			CSRFToken:              csrf,
 		}
 
 		// установка заголовка ответа
@@ -91,54 +140,175 @@ func WebHandler(productRepo *repository.ProductRepository, manufacturerRepo *rep
 	}
 }
 
-// обработчик админ-панели
-func AdminHandler(productRepo *repository.ProductRepository) http.HandlerFunc {
+// Состояние отправленной формы продукта, не прошедшей проверку
+// ID 0 - форма создания, иначе форма редактирования продукта с этим id
+type ProductForm struct {
+	ID     int
+	Values url.Values
+	Errors models.ValidationErrors
+}
+
+// Сообщение об ошибке поля формы продукта id; пустая строка, если ошибки нет
+func (d WelcomePageData) FieldError(id int, field string) string {
+	if d.ProductForm == nil || d.ProductForm.ID != id {
+		return ""
+	}
+	for _, e := range d.ProductForm.Errors {
+		if e.Field == field {
+			return e.Message
+		}
+	}
+	return ""
+}
+
+// Значение поля формы продукта id: отправленное пользователем при ошибке проверки,
+// иначе текущее значение продукта (nil-указатель - пустая строка)
+func (d WelcomePageData) FormValue(id int, field string, current interface{}) string {
+	if d.ProductForm != nil && d.ProductForm.ID == id {
+		return d.ProductForm.Values.Get(field)
+	}
+	switch v := current.(type) {
+	case nil:
+		return ""
+	case *string:
+		if v == nil {
+			return ""
+		}
+		return *v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// id модального окна с формой, которую нужно открыть при загрузке страницы
+func (d WelcomePageData) ProductFormModal() string {
+	if d.ProductForm == nil {
+		return ""
+	}
+	if d.ProductForm.ID == 0 {
+		return "createProductModal"
+	}
+	return "editModal" + strconv.Itoa(d.ProductForm.ID)
+}
+
+// админ-панель; форма продукта с ошибками проверки показывается через нее же
+type AdminPage struct {
+	tmpl        *template.Template
+	productRepo *repository.ProductRepository
+	userRepo    *repository.UserRepository
+	auditRepo   *repository.AuditRepository
+}
+
+// конструктор админ-панели
+func NewAdminPage(productRepo *repository.ProductRepository, userRepo *repository.UserRepository, auditRepo *repository.AuditRepository) *AdminPage {
 	// предварительная загрузка и парсинг шаблонов при старте приложения
 	tmpl, err := template.ParseFiles("views/index.html", "views/admin.html")
 	// обработка ошибки загрузки шаблонов
 	if err != nil {
 		log.Fatalf("Ошибка загрузки шаблонов: %v", err)
 	}
+	return &AdminPage{tmpl: tmpl, productRepo: productRepo, userRepo: userRepo, auditRepo: auditRepo}
+}
 
-	return func(w http.ResponseWriter, r *http.Request) {
-		// получение всех продуктов из репозитория для админ-панели
-		products, err := productRepo.GetAll()
-		// обработка ошибки получения данных о продуктах
+// обработчик админ-панели
+func (a *AdminPage) Show(w http.ResponseWriter, r *http.Request) {
+	a.render(w, r, http.StatusOK, nil)
+}
+
+// рендеринг админ-панели с кодом status; form - форма продукта с ошибками или nil
+func (a *AdminPage) render(w http.ResponseWriter, r *http.Request, status int, form *ProductForm) {
+	// получение всех продуктов из репозитория для админ-панели
+	products, err := a.productRepo.GetAll()
+	// обработка ошибки получения данных о продуктах
+	if err != nil {
+		log.Printf("Ошибка получения данных о продуктах для админки: %v", err)
+		http.Error(w, "Ошибка получения данных о продуктах", http.StatusInternalServerError)
+		return
+	}
+
+	// создание структуры данных для шаблона
+	// если пользователь попал на /admin, считаем его авторизованным
+	role := roleFromContext(r)
+	data := WelcomePageData{
+		Products:        products,
+		IsAuthenticated: true, // устанавливаем в true, так как маршрут защищен Middleware
+		CanWrite:        role.Can(models.PermProductsWrite),
+		CanDelete:       role.Can(models.PermProductsDelete),
+		CanManageUsers:  role.Can(models.PermUsersManage),
+		CanViewAudit:    role.Can(models.PermAuditRead),
+		CSRFToken:       csrfTokenFromContext(r),
+		ProductForm:     form,
+	}
+	if claims := claimsFromContext(r); claims != nil {
+		data.CurrentUserID = claims.UserID
+	}
+
+	// список пользователей показывается только тем, кто может ими управлять
+	if data.CanManageUsers {
+		data.UserQuery = strings.TrimSpace(r.URL.Query().Get("user_query"))
+		users, err := a.userRepo.GetAll(data.UserQuery)
 		if err != nil {
-			log.Printf("Ошибка получения данных о продуктах для админки: %v", err)
-			http.Error(w, "Ошибка получения данных о продуктах", http.StatusInternalServerError)
+			log.Printf("Ошибка получения пользователей для админки: %v", err)
+			http.Error(w, "Ошибка получения данных о пользователях", http.StatusInternalServerError)
 			return
 		}
+		data.Users = users
+	}
 
-		// создание структуры данных для шаблона
-		// если пользователь попал на /admin, считаем его авторизованным
-		data := WelcomePageData{
-			Products:        products,
-			IsAuthenticated: true, // устанавливаем в true, так как маршрут защищен Middleware
+	// последние записи журнала аудита с фильтрами audit_actor, audit_action и audit_entity
+	if data.CanViewAudit {
+		query := r.URL.Query()
+		data.AuditFilter = repository.AuditFilter{
+			Actor:      strings.TrimSpace(query.Get("audit_actor")),
+			Action:     models.AuditAction(strings.TrimSpace(query.Get("audit_action"))),
+			EntityType: strings.TrimSpace(query.Get("audit_entity")),
+			Limit:      50,
 		}
-
-		// установка заголовка ответа
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		// выполнение шаблона "admin" с передачей данных
-		if err := tmpl.ExecuteTemplate(w, "admin", data); err != nil {
-			// обработка ошибки выполнения шаблона
-			log.Printf("Ошибка выполнения шаблона 'admin': %v", err)
-			http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		entries, err := a.auditRepo.List(data.AuditFilter)
+		if err != nil {
+			log.Printf("Ошибка получения журнала аудита для админки: %v", err)
+			http.Error(w, "Ошибка получения журнала аудита", http.StatusInternalServerError)
+			return
 		}
+		data.AuditEntries = entries
+	}
+
+	// шаблон выполняется в буфер, чтобы код ответа не был отправлен до ошибки шаблона
+	var buf bytes.Buffer
+	// выполнение шаблона "admin" с передачей данных
+	if err := a.tmpl.ExecuteTemplate(&buf, "admin", data); err != nil {
+		// обработка ошибки выполнения шаблона
+		log.Printf("Ошибка выполнения шаблона 'admin': %v", err)
+		http.Error(w, "Внутренняя ошибка сервера", http.StatusInternalServerError)
+		return
 	}
+	// установка заголовка ответа
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
 }
 
-// Обработчик выхода пользователя
-func LogoutHandler() http.HandlerFunc {
+// Обработчик выхода пользователя; подключается после AuthMiddleware и проверки CSRF
+// Отзывает сессию браузера; с параметром all=1 завершает все сессии пользователя
+func LogoutHandler(sessions *repository.SessionRepository) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// установка cookie token со сроком действия в прошлом
-		http.SetCookie(w, &http.Cookie{
-			Name:     "token",
-			Value:    "", // обнуление значения
-			Path:     "/",
-			Expires:  time.Now().Add(-1 * time.Hour), // устанавка истекшего времени
-			HttpOnly: true,
-		})
+		claims := claimsFromContext(r)
+		// действующий токен доступа вносится в список отозванных
+		if err := sessions.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+			log.Printf("Ошибка отзыва токена: %v", err)
+		}
+		// отзыв текущей сессии или всех сессий пользователя
+		var err error
+		if r.FormValue("all") == "1" {
+			err = sessions.RevokeAllForUser(claims.UserID)
+		} else {
+			err = sessions.Revoke(claims.SessionID)
+		}
+		if err != nil {
+			log.Printf("Ошибка отзыва сессии: %v", err)
+		}
+		// удаление cookie сессии
+		clearSessionCookies(w)
 		// перенаправление на страницу входа
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	}
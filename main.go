@@ -1,30 +1,85 @@
 package main
 
 import (
+	"bufio"
+	"cosmetics/config"
 	"cosmetics/database"
 	"cosmetics/handlers"
+	"cosmetics/models"
 	"cosmetics/repository"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
+	//Флаги командной строки
+	createAdmin := flag.String("create-admin", "", "создать администратора с указанным логином (или назначить роль admin существующему) и завершить работу")
+	flag.Parse()
+
+	//Настройки сервера
+	cfg := config.Load()
+
 	//Инициализация базы данных
-	if err := database.InitDB(); err != nil {
+	if err := database.InitDB(cfg.DBPath); err != nil {
 		log.Fatal("Не удалось подключиться к БД: ", err)
 	}
 
 	//Репозитории
 	productRepo := repository.NewProductRepository(database.DB)
+	//Полнотекстовый поиск по продуктам (FTS5, если SQLite собран с тегом sqlite_fts5)
+	fullText, err := database.SetupSearch(database.DB)
+	if err != nil {
+		log.Fatal("Не удалось подготовить поиск продуктов: ", err)
+	}
+	productRepo.FullText = fullText
 	manufacturerRepo := repository.NewManufacturerRepository(database.DB)
 	userRepo := repository.NewUserRepository(database.DB)
+	inviteRepo := repository.NewInviteRepository(database.DB)
+	sessionRepo := repository.NewSessionRepository(database.DB)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(database.DB)
+	passwordResetRepo := repository.NewPasswordResetRepository(database.DB)
+	twoFactorRepo := repository.NewTwoFactorRepository(database.DB)
+	identityRepo := repository.NewIdentityRepository(database.DB)
+	apiKeyRepo := repository.NewAPIKeyRepository(database.DB)
+	auditRepo := repository.NewAuditRepository(database.DB)
+	structureRepo := repository.NewStructureRepository(database.DB)
+	familyRepo := repository.NewFamilyRepository(database.DB)
+
+	//Стоимость bcrypt для новых хешей паролей
+	models.PasswordCost = cfg.BcryptCost
+
+	//Создание первого администратора из командной строки
+	if *createAdmin != "" {
+		if err := bootstrapAdmin(userRepo, handlers.PasswordPolicy(cfg), *createAdmin); err != nil {
+			log.Fatal("Не удалось создать администратора: ", err)
+		}
+		return
+	}
+
+	//Ключи подписи JWT
+	if err := handlers.LoadSigningKeys(cfg); err != nil {
+		log.Fatal("Не удалось загрузить ключи JWT: ", err)
+	}
+	//Ключ CSRF-токенов форм
+	handlers.LoadCSRFKey(cfg)
 
 	//Обработчики
-	productHandler := handlers.NewProductHandler(productRepo)
-	manufacturerHandler := handlers.NewManufacturerHandler(manufacturerRepo)
-	userHandler := handlers.NewUserHandler(userRepo)
+	productHandler := handlers.NewProductHandler(productRepo, manufacturerRepo, auditRepo, cfg)
+	manufacturerHandler := handlers.NewManufacturerHandler(manufacturerRepo, auditRepo)
+	structureHandler := handlers.NewStructureHandler(structureRepo, productRepo, auditRepo)
+	familyHandler := handlers.NewFamilyHandler(familyRepo, structureRepo, auditRepo)
+	userHandler := handlers.NewUserHandler(userRepo, inviteRepo, sessionRepo, loginAttemptRepo, passwordResetRepo, twoFactorRepo, auditRepo, cfg)
+	inviteHandler := handlers.NewInviteHandler(inviteRepo, cfg)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyRepo)
+	auditHandler := handlers.NewAuditHandler(auditRepo)
+	adminPage := handlers.NewAdminPage(productRepo, userRepo, auditRepo)
+	auth := handlers.NewAuthenticator(userRepo, sessionRepo, apiKeyRepo, cfg)
 
 	//Маршрутизатор
 	r := mux.NewRouter()
@@ -35,39 +90,164 @@ func main() {
 	r.PathPrefix("/js/").Handler(staticFileHandler)
 	r.PathPrefix("/assets/").Handler(staticFileHandler)
 
+	//Открытые ключи для проверки JWT другими сервисами
+	r.HandleFunc("/.well-known/jwks.json", handlers.JWKSHandler).Methods("GET")
+
 	//Публичные страницы работы с пользователем
 	r.HandleFunc("/", handlers.WebHandler(productRepo, manufacturerRepo)).Methods("GET")
 	r.HandleFunc("/login", userHandler.LoginPage).Methods("GET")
 	r.HandleFunc("/register", userHandler.RegisterPage).Methods("GET")
 	r.HandleFunc("/login", userHandler.LoginFormHandler).Methods("POST")
-	r.HandleFunc("/register", userHandler.RegisterUser).Methods("POST")
-	r.HandleFunc("/logout", handlers.LogoutHandler()).Methods("POST", "GET")
+	r.HandleFunc("/login/2fa", userHandler.TwoFactorFormHandler).Methods("POST")
+	//Вход через провайдера OpenID Connect, если он настроен
+	if cfg.OIDCIssuer != "" {
+		oidcHandler := handlers.NewOIDCHandler(userHandler, identityRepo, cfg)
+		r.HandleFunc("/login/oidc", oidcHandler.Login).Methods("GET")
+		r.HandleFunc("/login/oidc/callback", oidcHandler.Callback).Methods("GET")
+	}
+	r.HandleFunc("/register", userHandler.RegisterFormHandler).Methods("POST")
+	r.Handle("/logout", auth.AuthMiddleware(handlers.VerifyCSRF(handlers.RequireSession(handlers.LogoutHandler(sessionRepo))))).Methods("POST")
+	r.HandleFunc("/reset-password", userHandler.ResetPasswordPage).Methods("GET")
+	r.HandleFunc("/reset-password", userHandler.ResetPasswordFormHandler).Methods("POST")
 
 	//Публичные пути продуктов
 	r.HandleFunc("/api/products", productHandler.GetProducts).Methods("GET")
+	r.HandleFunc("/api/products/search", productHandler.SearchProducts).Methods("GET")
+	r.HandleFunc("/api/products/suggest", productHandler.SuggestProducts).Methods("GET")
 	r.HandleFunc("/api/products/{id}", productHandler.GetProduct).Methods("GET")
-
-	//Формы для продукта
-	r.HandleFunc("/api/products", handlers.HandleProductFormSubmission(productHandler)).Methods("POST")
-	r.HandleFunc("/api/products/{id}", handlers.HandleProductFormSubmission(productHandler)).Methods("POST")
+	r.HandleFunc("/api/products/{id}/structures", structureHandler.GetProductStructures).Methods("GET")
+	r.HandleFunc("/api/products/{id}/label", structureHandler.GetProductLabel).Methods("GET")
 
 	//Авторизация по JWT-токену
 	r.HandleFunc("/api/login", userHandler.LoginUser).Methods("POST")
+	r.HandleFunc("/api/login/2fa", userHandler.LoginTwoFactor).Methods("POST")
 	r.HandleFunc("/api/register", userHandler.RegisterUser).Methods("POST")
+	r.HandleFunc("/api/token/refresh", userHandler.RefreshToken).Methods("POST")
+	r.HandleFunc("/api/password/reset", userHandler.ResetPassword).Methods("POST")
 
-	//Закрытые пути
+	//Закрытые пути; изменяющие запросы с cookie сессии требуют CSRF-токен
 	api := r.PathPrefix("/api").Subrouter()
-	api.Use(handlers.AuthMiddleware)
+	api.Use(auth.AuthMiddleware)
+	api.Use(handlers.VerifyCSRF)
+
+	//Формы для продукта (права проверяются внутри в зависимости от _method)
+	//JSON-запросы к тем же путям обрабатываются маршрутами ниже
+	productForm := handlers.HandleProductFormSubmission(productHandler, adminPage)
+	formContentType := "application/x-www-form-urlencoded|multipart/form-data"
+	api.Handle("/products", productForm).Methods("POST").HeadersRegexp("Content-Type", formContentType)
+	api.Handle("/products/{id}", productForm).Methods("POST").HeadersRegexp("Content-Type", formContentType)
+
+	//Операции со своей учетной записью (недоступны по API-ключу)
+	api.Handle("/logout", handlers.RequireSession(userHandler.LogoutUser)).Methods("POST")
+	api.Handle("/logout/all", handlers.RequireSession(userHandler.LogoutAll)).Methods("POST")
+	api.Handle("/me/password", handlers.RequireSession(userHandler.ChangePassword)).Methods("POST")
+	api.Handle("/me/2fa/setup", handlers.RequireSession(userHandler.SetupTwoFactor)).Methods("POST")
+	api.Handle("/me/2fa/enable", handlers.RequireSession(userHandler.EnableTwoFactor)).Methods("POST")
+	api.Handle("/me/2fa/disable", handlers.RequireSession(userHandler.DisableTwoFactor)).Methods("POST")
+	api.Handle("/me/2fa/recovery-codes", handlers.RequireSession(userHandler.RegenerateRecoveryCodes)).Methods("POST")
+
+	api.Handle("/manufacturers", handlers.RequirePermission(models.PermManufacturersWrite, manufacturerHandler.CreateManufacturer)).Methods("POST")
+	api.Handle("/manufacturers/{id}", handlers.RequirePermission(models.PermManufacturersWrite, manufacturerHandler.UpdateManufacturer)).Methods("PUT")
+	api.Handle("/manufacturers/{id}", handlers.RequirePermission(models.PermManufacturersDelete, manufacturerHandler.DeleteManufacturer)).Methods("DELETE")
+	api.Handle("/manufacturers", handlers.RequirePermission(models.PermManufacturersRead, manufacturerHandler.GetManufacturers)).Methods("GET")
+	api.Handle("/manufacturers/{id}", handlers.RequirePermission(models.PermManufacturersRead, manufacturerHandler.GetManufacturer)).Methods("GET")
+
+	api.Handle("/products", handlers.RequirePermission(models.PermProductsWrite, productHandler.CreateProduct)).Methods("POST")
+	api.Handle("/products/{id}", handlers.RequirePermission(models.PermProductsWrite, productHandler.UpdateProduct)).Methods("PUT")
+	api.Handle("/products/{id}", handlers.RequirePermission(models.PermProductsDelete, productHandler.DeleteProduct)).Methods("DELETE")
+
+	api.Handle("/structures", handlers.RequirePermission(models.PermStructuresWrite, structureHandler.CreateStructure)).Methods("POST")
+	api.Handle("/structures/{id}", handlers.RequirePermission(models.PermStructuresWrite, structureHandler.UpdateStructure)).Methods("PUT")
+	api.Handle("/structures/{id}", handlers.RequirePermission(models.PermStructuresDelete, structureHandler.DeleteStructure)).Methods("DELETE")
+	api.Handle("/structures/{id}/merge", handlers.RequirePermission(models.PermStructuresDelete, structureHandler.MergeStructures)).Methods("POST")
+	api.Handle("/structures", handlers.RequirePermission(models.PermStructuresRead, structureHandler.GetStructures)).Methods("GET")
+	api.Handle("/structures/resolve", handlers.RequirePermission(models.PermStructuresRead, structureHandler.ResolveStructure)).Methods("GET")
+	api.Handle("/structures/{id}", handlers.RequirePermission(models.PermStructuresRead, structureHandler.GetStructure)).Methods("GET")
 
-	api.HandleFunc("/manufacturers", manufacturerHandler.CreateManufacturer).Methods("POST")
-	api.HandleFunc("/manufacturers/{id}", manufacturerHandler.UpdateManufacturer).Methods("PUT")
-	api.HandleFunc("/manufacturers/{id}", manufacturerHandler.DeleteManufacturer).Methods("DELETE")
-	api.HandleFunc("/manufacturers", manufacturerHandler.GetManufacturers).Methods("GET")
-	api.HandleFunc("/manufacturers/{id}", manufacturerHandler.GetManufacturer).Methods("GET")
+	api.Handle("/families", handlers.RequirePermission(models.PermStructuresWrite, familyHandler.CreateFamily)).Methods("POST")
+	api.Handle("/families/{id}", handlers.RequirePermission(models.PermStructuresWrite, familyHandler.UpdateFamily)).Methods("PUT")
+	api.Handle("/families/{id}", handlers.RequirePermission(models.PermStructuresDelete, familyHandler.DeleteFamily)).Methods("DELETE")
+	api.Handle("/families", handlers.RequirePermission(models.PermStructuresRead, familyHandler.GetFamilies)).Methods("GET")
+	api.Handle("/families/{id}", handlers.RequirePermission(models.PermStructuresRead, familyHandler.GetFamily)).Methods("GET")
+	api.Handle("/families/{id}/structures", handlers.RequirePermission(models.PermStructuresRead, familyHandler.GetFamilyStructures)).Methods("GET")
+	api.Handle("/families/{id}/structures", handlers.RequirePermission(models.PermStructuresWrite, familyHandler.SetFamilyStructures)).Methods("PUT")
+	api.Handle("/families/{id}/structures", handlers.RequirePermission(models.PermStructuresWrite, familyHandler.AddFamilyStructures)).Methods("POST")
+	api.Handle("/families/{id}/structures/{structure_id}", handlers.RequirePermission(models.PermStructuresWrite, familyHandler.RemoveFamilyStructure)).Methods("DELETE")
+
+	api.Handle("/products/{id}/structures", handlers.RequirePermission(models.PermProductsWrite, structureHandler.SetProductStructures)).Methods("PUT")
+	api.Handle("/products/{id}/structures", handlers.RequirePermission(models.PermProductsWrite, structureHandler.AddProductStructures)).Methods("POST")
+	api.Handle("/products/{id}/structures/{structure_id}", handlers.RequirePermission(models.PermProductsWrite, structureHandler.RemoveProductStructure)).Methods("DELETE")
+	api.Handle("/products/{id}/inci", handlers.RequirePermission(models.PermProductsWrite, structureHandler.SetProductINCI)).Methods("POST")
+	api.Handle("/inci/parse", handlers.RequirePermission(models.PermStructuresRead, structureHandler.ParseINCI)).Methods("POST")
+
+	api.Handle("/invites", handlers.RequirePermission(models.PermUsersManage, inviteHandler.CreateInvite)).Methods("POST")
+	api.Handle("/invites", handlers.RequirePermission(models.PermUsersManage, inviteHandler.GetInvites)).Methods("GET")
+	api.Handle("/invites/{id}", handlers.RequirePermission(models.PermUsersManage, inviteHandler.DeleteInvite)).Methods("DELETE")
+
+	api.Handle("/users", handlers.RequirePermission(models.PermUsersManage, userHandler.GetUsers)).Methods("GET")
+	api.Handle("/users/{id}", handlers.RequirePermission(models.PermUsersManage, userHandler.GetUser)).Methods("GET")
+	api.Handle("/users/{id}", handlers.RequirePermission(models.PermUsersManage, userHandler.DeleteUser)).Methods("DELETE")
+	api.Handle("/users/{id}/role", handlers.RequirePermission(models.PermUsersManage, userHandler.UpdateUserRole)).Methods("PUT")
+	api.Handle("/users/{id}/disable", handlers.RequirePermission(models.PermUsersManage, userHandler.DisableUser)).Methods("POST")
+	api.Handle("/users/{id}/enable", handlers.RequirePermission(models.PermUsersManage, userHandler.EnableUser)).Methods("POST")
+	api.Handle("/users/{id}/unlock", handlers.RequirePermission(models.PermUsersManage, userHandler.UnlockUser)).Methods("POST")
+	api.Handle("/users/{id}/password-reset", handlers.RequirePermission(models.PermUsersManage, userHandler.CreatePasswordReset)).Methods("POST")
+	api.Handle("/users/{id}/2fa/reset", handlers.RequirePermission(models.PermUsersManage, userHandler.ResetTwoFactor)).Methods("POST")
+	api.Handle("/login-attempts", handlers.RequirePermission(models.PermUsersManage, userHandler.GetLoginAttempts)).Methods("GET")
+
+	api.Handle("/api-keys", handlers.RequirePermission(models.PermAPIKeysManage, apiKeyHandler.CreateAPIKey)).Methods("POST")
+	api.Handle("/api-keys", handlers.RequirePermission(models.PermAPIKeysManage, apiKeyHandler.GetAPIKeys)).Methods("GET")
+	api.Handle("/api-keys/{id}", handlers.RequirePermission(models.PermAPIKeysManage, apiKeyHandler.RevokeAPIKey)).Methods("DELETE")
+	api.Handle("/api-keys/{id}/usage", handlers.RequirePermission(models.PermAPIKeysManage, apiKeyHandler.GetAPIKeyUsage)).Methods("GET")
+
+	api.Handle("/audit", handlers.RequirePermission(models.PermAuditRead, auditHandler.GetAudit)).Methods("GET")
 
 	//Защита админ-панели от неавторизованных пользователей
-	r.Handle("/admin", handlers.AuthMiddleware(http.HandlerFunc(handlers.AdminHandler(productRepo)))).Methods("GET")
+	r.Handle("/admin", auth.AuthMiddleware(handlers.RequirePermission(models.PermAdminPanel, adminPage.Show))).Methods("GET")
+	r.Handle("/admin/users/{id}", auth.AuthMiddleware(handlers.VerifyCSRF(handlers.HandleUserFormSubmission(userHandler)))).Methods("POST")
 
 	//Запуск сервера
-	log.Fatal(http.ListenAndServe(":8080", r))
+	log.Fatal(http.ListenAndServe(cfg.Addr, r))
+}
+
+// Создание администратора: пароль берется из ADMIN_PASSWORD или читается из stdin
+// Если пользователь уже существует, ему назначается роль admin без смены пароля
+func bootstrapAdmin(userRepo *repository.UserRepository, policy models.PasswordPolicy, username string) error {
+	existing, err := userRepo.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if err := userRepo.UpdateRole(existing.ID, models.RoleAdmin); err != nil {
+			return err
+		}
+		log.Printf("Пользователю '%s' назначена роль admin", username)
+		return nil
+	}
+
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		fmt.Print("Пароль администратора: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("не удалось прочитать пароль: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+	if err := policy.Validate(password); err != nil {
+		return err
+	}
+
+	user := &models.User{UserName: username, Role: models.RoleAdmin}
+	if errs := user.Validate(); len(errs) > 0 {
+		return errs
+	}
+	if err := user.SetPassword(password); err != nil {
+		return err
+	}
+	if err := userRepo.CreateUser(user); err != nil {
+		return err
+	}
+	log.Printf("Администратор '%s' создан", username)
+	return nil
 }
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ингредиент, распознанный в строке состава INCI
+type INCIIngredient struct {
+	Text        string   `json:"text"` // фрагмент строки состава
+	Name        string   `json:"name"`
+	Synonyms    []string `json:"synonyms,omitempty"`     // названия в скобках и через косую черту
+	MayContain  bool     `json:"may_contain,omitempty"`  // из раздела «может содержать» (+/-)
+	StructureID int      `json:"structure_id,omitempty"` // найденный или созданный ингредиент
+	Known       bool     `json:"known"`                  // ингредиент уже есть в справочнике
+}
+
+// Результат разбора строки состава: распознанные ингредиенты по порядку, названия новых ингредиентов
+// и, если состав сохранен (не dry_run), новый состав продукта
+type INCIComposition struct {
+	Ingredients []INCIIngredient `json:"ingredients"`
+	Unknown     []string         `json:"unknown"`
+	DryRun      bool             `json:"dry_run"`
+	Structures  []Structure      `json:"structures,omitempty"`
+}
+
+var (
+	// заголовок перед составом: "Ingredients:", "INCI:", "Состав:"
+	inciHeaderPattern = regexp.MustCompile(`(?i)^\s*(?:ingredients|ingrédients|inci|состав|ингредиенты)\s*:`)
+	// начало раздела «может содержать» (красители, которые есть не во всех оттенках):
+	// "May contain (+/-):", "[+/-:", "Peut contenir", "Может содержать"
+	mayContainPattern = regexp.MustCompile(`(?i)[\[(]?\s*(?:may\s+(?:also\s+)?contain|peut\s+contenir|может\s+содержать|\+\s*/\s*-)\s*(?:[\[(]\s*\+\s*/\s*-\s*[\])])?\s*:?`)
+	// номер красителя Colour Index: CI 77891, C.I. 77891, CI77891
+	colourIndexPattern = regexp.MustCompile(`(?i)^c\.?\s*i\.?\s*(\d{5})$`)
+	// название с уточнением в скобках в конце: "Parfum (Fragrance)"
+	trailingParenPattern = regexp.MustCompile(`^(.*?)\s*\(([^()]*)\)$`)
+)
+
+// Разбор строки состава INCI на ингредиенты по порядку
+// Ингредиенты разделяются запятыми и точками с запятой вне скобок (запятая между цифрами,
+// как в 1,2-Hexanediol, частью названия), уточнение в скобках в конце и варианты через косую черту
+// из отдельных слов (Aqua/Water/Eau) становятся синонимами, номера CI приводятся к виду "CI 77891",
+// ингредиенты после "May contain" или "+/-" отмечаются MayContain; повторы (в том числе
+// по синонимам) пропускаются; текст после точки, завершающей состав (сноски), отбрасывается
+func ParseINCI(text string) []INCIIngredient {
+	text = inciHeaderPattern.ReplaceAllString(text, "")
+	main, extra := text, ""
+	if loc := mayContainPattern.FindStringIndex(text); loc != nil {
+		main, extra = text[:loc[0]], text[loc[1]:]
+		//раздел в скобках: закрывающая скобка в конце относится к маркеру
+		switch strings.TrimSpace(text[loc[0]:loc[1]])[0] {
+		case '[':
+			extra = trimLastRune(extra, ']')
+		case '(':
+			extra = trimLastRune(extra, ')')
+		}
+	}
+
+	var ingredients []INCIIngredient
+	seen := map[string]bool{}
+	for i, part := range []string{main, extra} {
+		for _, raw := range splitINCI(inciSentence(part)) {
+			ingredient, ok := parseINCIToken(raw)
+			if !ok || seen[SearchKey(ingredient.Name)] {
+				continue
+			}
+			//повтором считается и ингредиент, названный синонимом одного из предыдущих
+			for _, name := range append([]string{ingredient.Name}, ingredient.Synonyms...) {
+				seen[SearchKey(name)] = true
+			}
+			ingredient.MayContain = i == 1
+			ingredients = append(ingredients, ingredient)
+		}
+	}
+	return ingredients
+}
+
+// строка без последнего вхождения символа r
+func trimLastRune(s string, r rune) string {
+	if i := strings.LastIndexByte(s, byte(r)); i >= 0 {
+		return s[:i] + s[i+1:]
+	}
+	return s
+}
+
+// Строка состава до первой точки, завершающей предложение: вне скобок, перед пробелом или в конце
+// (а значит, не между цифрами, как в 0.5%) и не после однобуквенного сокращения (C.I. 77891); после нее обычно
+// идут сноски ("*from natural essential oils")
+func inciSentence(text string) string {
+	runes := []rune(text)
+	depth := 0
+	for i, r := range runes {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth > 0 {
+				depth--
+			}
+		case '.':
+			//точка между цифрами (0.5%) не стоит перед пробелом
+			if depth > 0 || i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+				continue
+			}
+			abbreviation := i > 0 && unicode.IsLetter(runes[i-1]) && (i == 1 || !unicode.IsLetter(runes[i-2]))
+			if !abbreviation {
+				return string(runes[:i])
+			}
+		}
+	}
+	return text
+}
+
+// разбиение строки состава по разделителям вне скобок
+func splitINCI(text string) []string {
+	var tokens []string
+	runes := []rune(text)
+	depth, start := 0, 0
+	for i, r := range runes {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth > 0 {
+				depth--
+			}
+		case ',', ';':
+			digits := r == ',' && i > 0 && i+1 < len(runes) && unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1])
+			if depth == 0 && !digits {
+				tokens = append(tokens, string(runes[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(tokens, string(runes[start:]))
+}
+
+// Ингредиент из фрагмента строки состава; false для пустого фрагмента
+func parseINCIToken(raw string) (INCIIngredient, bool) {
+	text := strings.Join(strings.Fields(raw), " ")
+	//сноски и точка в конце состава
+	text = strings.TrimRight(text, ".*°† ")
+	text = strings.TrimLeft(text, "*°† ")
+	if text == "" {
+		return INCIIngredient{}, false
+	}
+	ingredient := INCIIngredient{Text: text}
+
+	name := text
+	var synonyms []string
+	if m := trailingParenPattern.FindStringSubmatch(text); m != nil {
+		name = m[1]
+		synonyms = append(synonyms, inciAlternatives(m[2])...)
+	}
+	names := inciAlternatives(name)
+	if len(names) == 0 {
+		if len(synonyms) == 0 {
+			return INCIIngredient{}, false
+		}
+		names, synonyms = synonyms[:1], synonyms[1:]
+	}
+	ingredient.Name = names[0]
+	for _, synonym := range append(names[1:], synonyms...) {
+		if SearchKey(synonym) != SearchKey(ingredient.Name) {
+			ingredient.Synonyms = append(ingredient.Synonyms, synonym)
+		}
+	}
+	return ingredient, true
+}
+
+// Варианты названия: части через косую черту, если каждая - одно слово (Aqua/Water/Eau),
+// иначе название целиком (Caprylic/Capric Triglyceride); номера CI в едином виде
+func inciAlternatives(text string) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	parts := []string{text}
+	if split := strings.Split(text, "/"); len(split) > 1 {
+		words := true
+		for i, part := range split {
+			split[i] = strings.TrimSpace(part)
+			if split[i] == "" || strings.Contains(split[i], " ") {
+				words = false
+			}
+		}
+		if words {
+			parts = split
+		}
+	}
+	for i, part := range parts {
+		if m := colourIndexPattern.FindStringSubmatch(part); m != nil {
+			parts[i] = "CI " + m[1]
+		}
+	}
+	return parts
+}
+
+// Строка состава по ингредиентам: названия через запятую, ингредиенты MayContain - в разделе
+// "[+/- ...]"; ParseINCI может разделить название со скобками или косой чертой на название и синонимы,
+// поэтому совпадение разобранной строки с составом проверяет SameComposition
+func FormatINCI(structures []Structure) string {
+	var main, extra []string
+	for _, s := range structures {
+		if s.MayContain {
+			extra = append(extra, s.Name)
+		} else {
+			main = append(main, s.Name)
+		}
+	}
+	text := strings.Join(main, ", ")
+	if len(extra) > 0 {
+		text += " [+/- " + strings.Join(extra, ", ") + "]"
+	}
+	return text
+}
+
+// Совпадение ингредиентов строки состава с составом продукта: тот же порядок (ингредиенты MayContain
+// в конце, как в FormatINCI), те же разделы и те же ингредиенты по поисковому ключу названия
+// или фрагмента строки ("Parfum (Fragrance)" совпадает с ингредиентом с таким названием)
+func SameComposition(ingredients []INCIIngredient, structures []Structure) bool {
+	if len(ingredients) != len(structures) {
+		return false
+	}
+	ordered := make([]Structure, 0, len(structures))
+	for _, mayContain := range []bool{false, true} {
+		for _, s := range structures {
+			if s.MayContain == mayContain {
+				ordered = append(ordered, s)
+			}
+		}
+	}
+	for i, ingredient := range ingredients {
+		s := ordered[i]
+		key := SearchKey(s.Name)
+		if ingredient.MayContain != s.MayContain || SearchKey(ingredient.Name) != key && SearchKey(ingredient.Text) != key {
+			return false
+		}
+	}
+	return true
+}
+
+// ингредиент состава с концентрацией менее 1%: отмечен или указана концентрация меньше 1%
+func (s Structure) IsBelowOnePercent() bool {
+	return s.BelowOnePercent || s.Concentration != nil && *s.Concentration < 1
+}
+
+// название ингредиента на этикетке: название INCI, если оно задано
+func (s Structure) LabelName() string {
+	if s.INCIName != "" {
+		return s.INCIName
+	}
+	return s.Name
+}
+
+// Текст состава для этикетки: "Ingredients: " и названия INCI в порядке состава, ингредиенты
+// «может содержать» - в конце в разделе "[+/- ...]"; разбирается ParseINCI обратно в тот же состав
+func LabelText(structures []Structure) string {
+	if len(structures) == 0 {
+		return ""
+	}
+	named := make([]Structure, len(structures))
+	for i, s := range structures {
+		named[i] = Structure{Name: s.LabelName(), MayContain: s.MayContain}
+	}
+	return "Ingredients: " + FormatINCI(named) + "."
+}
+
+// состав продукта строкой INCI для формы админ-панели
+func (p Product) INCI() string {
+	return FormatINCI(p.Structures)
+}
+
+// ингредиент состава для записи: название, синонимы и раздел «может содержать»
+func (i INCIIngredient) Structure() Structure {
+	s := Structure{ID: i.StructureID, Name: i.Name, MayContain: i.MayContain}
+	for _, synonym := range i.Synonyms {
+		s.Synonyms = append(s.Synonyms, Synonym{Name: synonym})
+	}
+	return s
+}
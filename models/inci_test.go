@@ -0,0 +1,158 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseINCI(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []INCIIngredient
+	}{
+		{
+			name: "синоним в скобках в конце",
+			text: "Aqua (Water), Glycerin, Butyrospermum Parkii (Shea) Butter",
+			want: []INCIIngredient{
+				{Name: "Aqua", Synonyms: []string{"Water"}},
+				{Name: "Glycerin"},
+				{Name: "Butyrospermum Parkii (Shea) Butter"},
+			},
+		},
+		{
+			name: "повтор по синониму",
+			text: "Aqua (Water), Water, Glycerin",
+			want: []INCIIngredient{
+				{Name: "Aqua", Synonyms: []string{"Water"}},
+				{Name: "Glycerin"},
+			},
+		},
+		{
+			name: "косая черта",
+			text: "Aqua/Water/Eau, Caprylic/Capric Triglyceride",
+			want: []INCIIngredient{
+				{Name: "Aqua", Synonyms: []string{"Water", "Eau"}},
+				{Name: "Caprylic/Capric Triglyceride"},
+			},
+		},
+		{
+			name: "номера CI",
+			text: "Mica, C.I. 77891, CI77491, ci 77492",
+			want: []INCIIngredient{
+				{Name: "Mica"},
+				{Name: "CI 77891"},
+				{Name: "CI 77491"},
+				{Name: "CI 77492"},
+			},
+		},
+		{
+			name: "раздел +/- в скобках",
+			text: "Aqua, Glycerin [+/- CI 77891, CI 77491]",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "Glycerin"},
+				{Name: "CI 77891", MayContain: true},
+				{Name: "CI 77491", MayContain: true},
+			},
+		},
+		{
+			name: "раздел may contain после точки",
+			text: "Aqua, Glycerin. May contain: Mica, CI 77491",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "Glycerin"},
+				{Name: "Mica", MayContain: true},
+				{Name: "CI 77491", MayContain: true},
+			},
+		},
+		{
+			name: "запятая между цифрами",
+			text: "Aqua, 1,2-Hexanediol, Glycerin",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "1,2-Hexanediol"},
+				{Name: "Glycerin"},
+			},
+		},
+		{
+			name: "заголовок и точка в конце",
+			text: "Ingredients: Aqua, Glycerin.",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "Glycerin"},
+			},
+		},
+		{
+			name: "сноска после состава",
+			text: "Aqua, Linalool*. *from natural essential oils",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "Linalool"},
+			},
+		},
+		{
+			name: "сноска после раздела +/-",
+			text: "Aqua, Glycerin [+/- CI 77891]. *certified organic",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "Glycerin"},
+				{Name: "CI 77891", MayContain: true},
+			},
+		},
+		{
+			name: "сноска после номера CI с точками",
+			text: "Aqua, Mica, C.I. 77891. °natural origin",
+			want: []INCIIngredient{
+				{Name: "Aqua"},
+				{Name: "Mica"},
+				{Name: "CI 77891"},
+			},
+		},
+		{
+			name: "пустая строка",
+			text: "",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseINCI(tt.text)
+			//фрагмент исходной строки не сравнивается
+			for i := range got {
+				got[i].Text = ""
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseINCI(%q) = %+v, ожидалось %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameComposition(t *testing.T) {
+	stored := []Structure{
+		{Name: "Aqua"},
+		{Name: "CI 77891", MayContain: true},
+		{Name: "Parfum (Fragrance)"},
+		{Name: "Caprylic/Capric Triglyceride"},
+	}
+	tests := []struct {
+		name string
+		text string
+		want bool
+	}{
+		{name: "строка формы без изменений", text: FormatINCI(stored), want: true},
+		{name: "синоним в скобках у сохраненного названия", text: "Aqua (Water), Parfum (Fragrance), Caprylic/Capric Triglyceride [+/- CI 77891]", want: true},
+		{name: "другой порядок", text: "Parfum (Fragrance), Aqua, Caprylic/Capric Triglyceride [+/- CI 77891]", want: false},
+		{name: "ингредиент перенесен из раздела +/-", text: "Aqua, Parfum (Fragrance), Caprylic/Capric Triglyceride, CI 77891", want: false},
+		{name: "ингредиент удален", text: "Aqua, Parfum (Fragrance) [+/- CI 77891]", want: false},
+		{name: "другое название", text: "Aqua, Parfum, Caprylic/Capric Triglyceride [+/- CI 77891]", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SameComposition(ParseINCI(tt.text), stored); got != tt.want {
+				t.Errorf("SameComposition(%q) = %v, ожидалось %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
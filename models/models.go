@@ -1,8 +1,14 @@
 package models
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"encoding/json"
+	"slices"
+	"time"
 
-//производитель
+	"golang.org/x/crypto/bcrypt"
+)
+
+// производитель
 type Manufacturer struct {
 	ID          int    `json:"id"`
 	Title       string `json:"title"`
@@ -11,23 +17,147 @@ type Manufacturer struct {
 	ContactList string `json:"contact_list"`
 }
 
-//состав (единица состава)
+// состав (единица состава)
 type Structure struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	ProductCount int    `json:"product_count,omitempty"` // число продуктов с ингредиентом, заполняется в списке ингредиентов
+	INCIName     string `json:"inci_name,omitempty"`     // каноническое название по номенклатуре INCI
+	CASNumber    string `json:"cas_number,omitempty"`
+	ECNumber     string `json:"ec_number,omitempty"`
+	// в составе продукта: место на этикетке (с 1), концентрация в процентах по массе или отметка «менее 1%»
+	// и раздел «может содержать» (+/-)
+	Position        int      `json:"position,omitempty"`
+	Concentration   *float64 `json:"concentration,omitempty"`
+	BelowOnePercent bool     `json:"below_one_percent,omitempty"`
+	MayContain      bool     `json:"may_contain,omitempty"`
+	// синонимы и семейства, в которые ингредиент входит напрямую; заполняются при получении одного ингредиента
+	Synonyms []Synonym          `json:"synonyms,omitempty"`
+	Families []IngredientFamily `json:"families,omitempty"`
+}
+
+// Другое название ингредиента, например «Water» или «Вода» для Aqua; language - код языка ISO 639-1
+// Ингредиент находится по синониму так же, как по названию
+type Synonym struct {
+	Name     string `json:"name"`
+	Language string `json:"language,omitempty"`
+}
+
+// Семейство (группа) ингредиентов, например «парабены»; семейства образуют дерево по ParentID,
+// ингредиент может входить в несколько семейств
+// Счетчики учитывают ингредиенты семейства и всех его потомков
+type IngredientFamily struct {
+	ID             int                `json:"id"`
+	Name           string             `json:"name"`
+	ParentID       *int               `json:"parent_id"`
+	StructureCount int                `json:"structure_count"`
+	ProductCount   int                `json:"product_count"`
+	Children       []IngredientFamily `json:"children,omitempty"` // дочерние семейства в дереве
 }
 
 // пользователь
 type User struct {
 	ID       int    `json:"id"`
 	UserName string `json:"username"`
-	Password string `json:"password"` // В БД будет храниться хеш
+	Password string `json:"-"` // В БД будет храниться хеш, в ответы API не попадает
+	Role     Role   `json:"role"`
+	Disabled bool   `json:"disabled"` // отключенный пользователь не может войти
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"` // вход требует код TOTP
+
+	FailedLogins int        `json:"failed_logins"`          // неудачные попытки входа подряд
+	LockedUntil  *time.Time `json:"locked_until,omitempty"` // время окончания блокировки
+}
+
+// запись журнала попыток входа
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	UserName  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// приглашение для регистрации
+type Invite struct {
+	ID        int        `json:"id"`
+	Code      string     `json:"code,omitempty"` // открытый код, возвращается только при создании
+	Role      Role       `json:"role"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UsedBy    *int       `json:"used_by,omitempty"`
+}
+
+// роль пользователя
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"  // полный доступ, включая удаление и управление пользователями
+	RoleEditor Role = "editor" // чтение, создание и изменение записей
+	RoleViewer Role = "viewer" // только чтение
+)
+
+// право доступа в формате "ресурс:действие"
+type Permission string
+
+const (
+	PermProductsRead        Permission = "products:read"
+	PermProductsWrite       Permission = "products:write"
+	PermProductsDelete      Permission = "products:delete"
+	PermManufacturersRead   Permission = "manufacturers:read"
+	PermManufacturersWrite  Permission = "manufacturers:write"
+	PermManufacturersDelete Permission = "manufacturers:delete"
+	PermStructuresRead      Permission = "structures:read"
+	PermStructuresWrite     Permission = "structures:write"
+	PermStructuresDelete    Permission = "structures:delete"
+	PermUsersManage         Permission = "users:manage"
+	PermAPIKeysManage       Permission = "apikeys:manage"
+	PermAuditRead           Permission = "audit:read"
+	PermAdminPanel          Permission = "admin:read"
+)
+
+// все известные права; из них же выбираются области доступа API-ключей
+var Permissions = []Permission{
+	PermProductsRead, PermProductsWrite, PermProductsDelete,
+	PermManufacturersRead, PermManufacturersWrite, PermManufacturersDelete,
+	PermStructuresRead, PermStructuresWrite, PermStructuresDelete,
+	PermUsersManage, PermAPIKeysManage, PermAuditRead, PermAdminPanel,
+}
+
+// Проверка существования права
+func (p Permission) Valid() bool {
+	return slices.Contains(Permissions, p)
+}
+
+// Проверка существования роли
+func (r Role) Valid() bool {
+	return r == RoleAdmin || r == RoleEditor || r == RoleViewer
+}
+
+// Права ролей перечислены явно, чтобы новое право не доставалось ролям по одному названию действия:
+// просмотр - только каталог, редактор - каталог и админ-панель, журнал аудита и пользователи - только admin
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: Permissions,
+	RoleEditor: {
+		PermProductsRead, PermProductsWrite,
+		PermManufacturersRead, PermManufacturersWrite,
+		PermStructuresRead, PermStructuresWrite,
+		PermAdminPanel,
+	},
+	RoleViewer: {PermProductsRead, PermManufacturersRead, PermStructuresRead},
+}
+
+// Проверка наличия у роли права доступа
+func (r Role) Can(p Permission) bool {
+	return slices.Contains(rolePermissions[r], p)
 }
 
 // Хеширование пароля(используется bcrypt)
 func (u *User) SetPassword(password string) error {
 	//передача пароля и генерация хеша
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), PasswordCost)
 	//обработка ошибок
 	if err != nil {
 		return err
@@ -42,7 +172,71 @@ func (u *User) CheckPassword(password string) bool {
 	return err == nil
 }
 
-//продукт
+// API-ключ машинного клиента
+type APIKey struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	Key        string       `json:"key,omitempty"` // открытый ключ, возвращается только при создании
+	Prefix     string       `json:"prefix"`        // начало ключа для опознания в списке
+	Scopes     []Permission `json:"scopes"`
+	DailyQuota int          `json:"daily_quota"` // запросов в сутки (UTC), 0 - без ограничения
+	CreatedBy  string       `json:"created_by"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time   `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty"`
+	UsedToday  int          `json:"used_today"`
+}
+
+// Проверка наличия у ключа области доступа
+func (k *APIKey) Can(p Permission) bool {
+	return slices.Contains(k.Scopes, p)
+}
+
+// число запросов по API-ключу за сутки
+type APIKeyUsage struct {
+	Day   string `json:"day"` // дата в формате 2006-01-02 (UTC)
+	Count int    `json:"count"`
+}
+
+// действие, записанное в журнал аудита
+type AuditAction string
+
+const (
+	AuditCreate         AuditAction = "create"
+	AuditUpdate         AuditAction = "update"
+	AuditDelete         AuditAction = "delete"
+	AuditMerge          AuditAction = "merge"
+	AuditRoleChange     AuditAction = "role_change"
+	AuditDisable        AuditAction = "disable"
+	AuditEnable         AuditAction = "enable"
+	AuditUnlock         AuditAction = "unlock"
+	AuditTwoFactorReset AuditAction = "2fa_reset"
+	AuditPasswordReset  AuditAction = "password_reset"
+)
+
+// типы сущностей журнала аудита
+const (
+	AuditEntityProduct      = "product"
+	AuditEntityManufacturer = "manufacturer"
+	AuditEntityStructure    = "structure"
+	AuditEntityFamily       = "ingredient_family"
+	AuditEntityUser         = "user"
+)
+
+// запись журнала аудита: кто, что и над какой сущностью сделал, с состоянием до и после
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     AuditAction     `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty"` // пусто при создании
+	After      json.RawMessage `json:"after,omitempty"`  // пусто при удалении
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// продукт
 type Product struct {
 	ID                int           `json:"id"`
 	Title             string        `json:"title"`
@@ -56,20 +250,86 @@ type Product struct {
 	Structures        []Structure   `json:"structures,omitempty"`
 }
 
-//связь многое-ко-многим продукт/единица состава
+// результат поиска продуктов: релевантность (меньше - выше) и фрагмент текста с совпадениями
+type ProductSearchResult struct {
+	Product
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"` // HTML: текст экранирован, совпадения выделены <mark>
+}
+
+// типы подсказок поиска
+const (
+	SuggestionProduct      = "product"
+	SuggestionManufacturer = "manufacturer"
+	SuggestionStructure    = "structure"
+)
+
+// подсказка поиска: название продукта, производителя или ингредиента и его популярность
+// (просмотры продукта или число продуктов производителя и ингредиента)
+type Suggestion struct {
+	Type       string `json:"type"`
+	ID         int    `json:"id"`
+	Text       string `json:"text"`
+	Popularity int    `json:"popularity"`
+}
+
+// связь многое-ко-многим продукт/единица состава
 type ProductStructure struct {
 	ProductID   int `json:"product_id"`
 	StructureID int `json:"structure_id"`
 }
 
-//ответ API
+// текст состава для этикетки продукта и ингредиенты в порядке этикетки
+type ProductLabel struct {
+	ProductID  int         `json:"product_id"`
+	Text       string      `json:"text"`
+	Structures []Structure `json:"structures"`
+}
+
+// ответ API
 type Response struct {
-	Message string      `json:"message"`
-	Data    interface{} `json:"data,omitempty"`
+	Message    string      `json:"message"`
+	Data       interface{} `json:"data,omitempty"`
+	Pagination *Pagination `json:"pagination,omitempty"`
+	DidYouMean string      `json:"did_you_mean,omitempty"` // исправленный поисковый запрос, по которому найдены результаты
+}
+
+// сведения о странице списка: всего записей по фильтру и ссылки на соседние страницы
+type Pagination struct {
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset,omitempty"`
+	Next   string `json:"next,omitempty"`
+	Prev   string `json:"prev,omitempty"`
 }
 
-//ответ со сведениями об ошибке
+// ответ со сведениями об ошибке
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message"`
 }
+
+// ответ с ошибками проверки полей (422)
+type ValidationErrorResponse struct {
+	Error   string           `json:"error"`
+	Message string           `json:"message"`
+	Errors  ValidationErrors `json:"errors"`
+}
+
+// открытый ключ в формате JWK (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`   // модуль RSA
+	E   string `json:"e,omitempty"`   // экспонента RSA
+	Crv string `json:"crv,omitempty"` // кривая OKP/EC
+	X   string `json:"x,omitempty"`   // открытый ключ OKP / координата EC
+	Y   string `json:"y,omitempty"`   // координата EC
+}
+
+// набор ключей JWKS
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
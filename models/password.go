@@ -0,0 +1,109 @@
+package models
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Стоимость bcrypt для новых хешей; хеши с меньшей стоимостью пересчитываются при входе
+var PasswordCost = bcrypt.DefaultCost
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// распространенные пароли в нижнем регистре
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+// разбор списка паролей: по одному в строке, # - комментарий
+func parseCommonPasswords(data string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[strings.ToLower(line)] = struct{}{}
+	}
+	return set
+}
+
+// Политика паролей
+type PasswordPolicy struct {
+	MinLength      int  // минимальная длина в символах
+	RequireUpper   bool // хотя бы одна заглавная буква
+	RequireLower   bool // хотя бы одна строчная буква
+	RequireDigit   bool // хотя бы одна цифра
+	RequireSpecial bool // хотя бы один символ, не являющийся буквой или цифрой
+	RejectCommon   bool // отклонять пароли из списка распространенных
+}
+
+// ошибка несоответствия пароля политике со списком нарушений
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "Пароль не соответствует требованиям: " + strings.Join(e.Violations, "; ")
+}
+
+// Проверка пароля на соответствие политике
+func (p PasswordPolicy) Validate(password string) error {
+	var violations []string
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("длина не менее %d символов", p.MinLength))
+	}
+
+	var upper, lower, digit, special bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			special = true
+		}
+	}
+	if p.RequireUpper && !upper {
+		violations = append(violations, "хотя бы одна заглавная буква")
+	}
+	if p.RequireLower && !lower {
+		violations = append(violations, "хотя бы одна строчная буква")
+	}
+	if p.RequireDigit && !digit {
+		violations = append(violations, "хотя бы одна цифра")
+	}
+	if p.RequireSpecial && !special {
+		violations = append(violations, "хотя бы один специальный символ")
+	}
+	if p.RejectCommon {
+		if _, found := commonPasswords[strings.ToLower(password)]; found {
+			violations = append(violations, "пароль слишком распространен")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// Проверка, является ли ошибка нарушением политики паролей
+func IsPasswordPolicyError(err error) bool {
+	var policyErr *PasswordPolicyError
+	return errors.As(err, &policyErr)
+}
+
+// Проверка, нужно ли пересчитать хеш пароля с текущей стоимостью bcrypt
+func (u *User) NeedsRehash() bool {
+	cost, err := bcrypt.Cost([]byte(u.Password))
+	return err == nil && cost < PasswordCost
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Транслитерация кириллицы латиницей для поискового ключа (упрощенная, как обычно набирают
+// русские слова латиницей); ё и е дают одно и то же, ъ и ь опускаются
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "h", 'ц': "c",
+	'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "i", 'є': "e", 'ґ': "g",
+}
+
+// латинские буквы с диакритикой, встречающиеся в названиях косметики, и их основа
+var latinBase = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ä': 'a', 'ã': 'a', 'å': 'a', 'ç': 'c', 'è': 'e', 'é': 'e',
+	'ê': 'e', 'ë': 'e', 'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i', 'ñ': 'n', 'ò': 'o', 'ó': 'o',
+	'ô': 'o', 'ö': 'o', 'õ': 'o', 'ø': 'o', 'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ý': 'y',
+	'ÿ': 'y', 'ß': 's',
+}
+
+// Поисковый ключ текста: приведение регистра (в том числе кириллицы), ё → е, снятие диакритики
+// с латинских букв и транслитерация кириллицы латиницей, так что «Крем», «крем» и «krem»
+// дают один ключ. Применяется и к индексируемым названиям, и к строке поиска
+func SearchKey(text string) string {
+	var b strings.Builder
+	b.Grow(len(text))
+	for _, r := range text {
+		r = unicode.ToLower(unicode.ToUpper(r))
+		if latin, ok := cyrillicToLatin[r]; ok {
+			b.WriteString(latin)
+			continue
+		}
+		if base, ok := latinBase[r]; ok {
+			r = base
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
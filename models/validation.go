@@ -0,0 +1,313 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ошибка проверки отдельного поля; code - машиночитаемый код (required, max_length, ...)
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// список ошибок проверки полей, возвращается клиенту с кодом 422
+type ValidationErrors []FieldError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, e := range v {
+		messages[i] = e.Field + ": " + e.Message
+	}
+	return "Ошибка проверки данных: " + strings.Join(messages, "; ")
+}
+
+// наличие ошибки для поля
+func (v ValidationErrors) Has(field string) bool {
+	for _, e := range v {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// добавление ошибки, если для поля ошибки еще нет
+func (v ValidationErrors) Add(field, code, message string) ValidationErrors {
+	if v.Has(field) {
+		return v
+	}
+	return append(v, FieldError{Field: field, Code: code, Message: message})
+}
+
+// Проверка, является ли ошибка ошибкой проверки полей; возвращает список ошибок
+func AsValidationErrors(err error) (ValidationErrors, bool) {
+	var v ValidationErrors
+	ok := errors.As(err, &v)
+	return v, ok
+}
+
+// Правило проверки поля значения типа T: Valid возвращает false при нарушении
+// Правила задаются списком для каждой модели и проверяются по порядку;
+// после первой ошибки поля остальные правила этого поля пропускаются
+type Rule[T any] struct {
+	Field   string
+	Code    string
+	Message string
+	Valid   func(v *T) bool
+}
+
+// Проверка значения по списку правил; nil, если нарушений нет
+func Check[T any](v *T, rules []Rule[T]) ValidationErrors {
+	var errs ValidationErrors
+	for _, rule := range rules {
+		if errs.Has(rule.Field) {
+			continue
+		}
+		if !rule.Valid(v) {
+			errs = append(errs, FieldError{Field: rule.Field, Code: rule.Code, Message: rule.Message})
+		}
+	}
+	return errs
+}
+
+// обязательное непустое строковое поле
+func Required[T any](field string, value func(*T) string) Rule[T] {
+	return Rule[T]{Field: field, Code: "required", Message: "Обязательное поле",
+		Valid: func(v *T) bool { return strings.TrimSpace(value(v)) != "" }}
+}
+
+// строковое поле не длиннее max символов
+func MaxLength[T any](field string, max int, value func(*T) string) Rule[T] {
+	return Rule[T]{Field: field, Code: "max_length", Message: fmt.Sprintf("Не более %d символов", max),
+		Valid: func(v *T) bool { return utf8.RuneCountInString(value(v)) <= max }}
+}
+
+// строковое поле не короче min символов
+func MinLength[T any](field string, min int, value func(*T) string) Rule[T] {
+	return Rule[T]{Field: field, Code: "min_length", Message: fmt.Sprintf("Не менее %d символов", min),
+		Valid: func(v *T) bool { return utf8.RuneCountInString(value(v)) >= min }}
+}
+
+// числовое поле больше нуля
+func Positive[T any](field string, value func(*T) float64) Rule[T] {
+	return Rule[T]{Field: field, Code: "positive", Message: "Значение должно быть больше нуля",
+		Valid: func(v *T) bool { return value(v) > 0 }}
+}
+
+// строковое поле, соответствующее регулярному выражению
+func Matches[T any](field string, re *regexp.Regexp, message string, value func(*T) string) Rule[T] {
+	return Rule[T]{Field: field, Code: "format", Message: message,
+		Valid: func(v *T) bool { return re.MatchString(value(v)) }}
+}
+
+// необязательная ссылка на изображение: абсолютный URL http(s) или относительный путь к файлу
+func ImageRef[T any](field string, value func(*T) string) Rule[T] {
+	return Rule[T]{Field: field, Code: "image_ref", Message: "Укажите URL http(s) или имя файла изображения, например product_1.jpg",
+		Valid: func(v *T) bool { return validImageRef(value(v)) }}
+}
+
+// допустимые символы относительного пути к изображению
+var imagePathPattern = regexp.MustCompile(`^[A-Za-z0-9_\-./]+$`)
+
+// проверка ссылки на изображение; пустая ссылка допустима
+func validImageRef(ref string) bool {
+	if ref == "" {
+		return true
+	}
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		u, err := url.Parse(ref)
+		return err == nil && u.Host != ""
+	}
+	if !imagePathPattern.MatchString(ref) || strings.HasPrefix(ref, "/") {
+		return false
+	}
+	for _, part := range strings.Split(ref, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return path.Ext(ref) != ""
+}
+
+// правила проверки продукта; существование производителя проверяется отдельно по БД
+var productRules = []Rule[Product]{
+	Required("title", func(p *Product) string { return p.Title }),
+	MaxLength("title", 100, func(p *Product) string { return p.Title }),
+	Required("description", func(p *Product) string { return p.Description }),
+	Required("application", func(p *Product) string { return p.Application }),
+	Positive("volume", func(p *Product) float64 { return p.Volume }),
+	Positive("manufacturer_id", func(p *Product) float64 { return float64(p.ManufacturerID) }),
+	ImageRef("photo", func(p *Product) string { return p.Photo }),
+	{Field: "structures", Code: "concentration", Message: "Концентрация ингредиента - больше 0 и не более 100%, с отметкой «менее 1%» - меньше 1%",
+		Valid: func(p *Product) bool { return validConcentrations(p.Structures) }},
+	{Field: "structures", Code: "concentration_sum", Message: "Сумма указанных концентраций ингредиентов не должна превышать 100%",
+		Valid: func(p *Product) bool { return concentrationSum(p.Structures) <= 100+concentrationEpsilon }},
+	{Field: "structures", Code: "label_order", Message: "Ингредиенты с концентрацией от 1% перечисляются по убыванию концентрации перед ингредиентами менее 1%",
+		Valid: func(p *Product) bool { return validLabelOrder(p.Structures) }},
+}
+
+// погрешность сравнения концентраций в процентах
+const concentrationEpsilon = 1e-9
+
+// проверка значений концентраций и отметок «менее 1%» состава
+func validConcentrations(structures []Structure) bool {
+	for _, s := range structures {
+		if s.Concentration == nil {
+			continue
+		}
+		c := *s.Concentration
+		if c <= 0 || c > 100 || s.BelowOnePercent && c >= 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// сумма указанных концентраций состава в процентах
+func concentrationSum(structures []Structure) float64 {
+	sum := 0.0
+	for _, s := range structures {
+		if s.Concentration != nil {
+			sum += *s.Concentration
+		}
+	}
+	return sum
+}
+
+// Проверка порядка состава по правилам маркировки: указанные концентрации от 1% не возрастают,
+// и ни одна из них не идет после ингредиента менее 1%; ингредиенты без концентрации и раздел
+// «может содержать» не проверяются
+func validLabelOrder(structures []Structure) bool {
+	previous, belowOne := 100.0+concentrationEpsilon, false
+	for _, s := range structures {
+		if s.MayContain {
+			continue
+		}
+		if s.IsBelowOnePercent() {
+			belowOne = true
+			continue
+		}
+		if s.Concentration == nil {
+			continue
+		}
+		if belowOne || *s.Concentration > previous+concentrationEpsilon {
+			return false
+		}
+		previous = *s.Concentration
+	}
+	return true
+}
+
+// правила проверки производителя
+var manufacturerRules = []Rule[Manufacturer]{
+	Required("title", func(m *Manufacturer) string { return m.Title }),
+	MaxLength("title", 100, func(m *Manufacturer) string { return m.Title }),
+	Required("country", func(m *Manufacturer) string { return m.Country }),
+	MaxLength("country", 100, func(m *Manufacturer) string { return m.Country }),
+	MaxLength("address", 255, func(m *Manufacturer) string { return m.Address }),
+	MaxLength("contact_list", 255, func(m *Manufacturer) string { return m.ContactList }),
+}
+
+// допустимый логин: буквы, цифры и символы . _ @ + - (логином может быть email)
+var userNamePattern = regexp.MustCompile(`^[\p{L}\p{N}._@+\-]+$`)
+
+// правила проверки пользователя; пароль проверяется политикой паролей
+var userRules = []Rule[User]{
+	Required("username", func(u *User) string { return u.UserName }),
+	MinLength("username", 3, func(u *User) string { return u.UserName }),
+	MaxLength("username", 64, func(u *User) string { return u.UserName }),
+	Matches("username", userNamePattern, "Допустимы буквы, цифры и символы . _ @ + -", func(u *User) string { return u.UserName }),
+	{Field: "role", Code: "unknown_role", Message: "Неизвестная роль",
+		Valid: func(u *User) bool { return u.Role == "" || u.Role.Valid() }},
+}
+
+// номера веществ: CAS (2-7 цифр, 2 цифры и контрольная) и EC (3-3-1); код языка ISO 639-1
+var (
+	casPattern      = regexp.MustCompile(`^(\d{2,7})-(\d{2})-(\d)$`)
+	ecPattern       = regexp.MustCompile(`^(\d{3})-(\d{3})-(\d)$`)
+	languagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// Проверка контрольной цифры номера CAS: сумма цифр справа налево, умноженных на 1, 2, 3..., по модулю 10
+// Пустой номер допустим
+func validCAS(number string) bool {
+	if number == "" {
+		return true
+	}
+	m := casPattern.FindStringSubmatch(number)
+	if m == nil {
+		return false
+	}
+	digits := m[1] + m[2]
+	sum := 0
+	for i := range digits {
+		sum += int(digits[len(digits)-1-i]-'0') * (i + 1)
+	}
+	return sum%10 == int(m[3][0]-'0')
+}
+
+// Проверка контрольной цифры номера EC: сумма первых шести цифр, умноженных на 1...6, по модулю 11
+// Пустой номер допустим
+func validEC(number string) bool {
+	if number == "" {
+		return true
+	}
+	m := ecPattern.FindStringSubmatch(number)
+	if m == nil {
+		return false
+	}
+	digits := m[1] + m[2]
+	sum := 0
+	for i := range digits {
+		sum += int(digits[i]-'0') * (i + 1)
+	}
+	return sum%11 == int(m[3][0]-'0')
+}
+
+// правила проверки ингредиента; уникальность названий и синонимов проверяется по БД
+var structureRules = []Rule[Structure]{
+	Required("name", func(s *Structure) string { return s.Name }),
+	MaxLength("name", 255, func(s *Structure) string { return s.Name }),
+	MaxLength("inci_name", 255, func(s *Structure) string { return s.INCIName }),
+	{Field: "cas_number", Code: "format", Message: "Номер CAS вида 7732-18-5 с верной контрольной цифрой",
+		Valid: func(s *Structure) bool { return validCAS(s.CASNumber) }},
+	{Field: "ec_number", Code: "format", Message: "Номер EC вида 231-791-2 с верной контрольной цифрой",
+		Valid: func(s *Structure) bool { return validEC(s.ECNumber) }},
+	{Field: "synonyms", Code: "invalid", Message: "У синонима должно быть название до 255 символов и код языка из двух строчных латинских букв",
+		Valid: func(s *Structure) bool {
+			for _, synonym := range s.Synonyms {
+				if strings.TrimSpace(synonym.Name) == "" || utf8.RuneCountInString(synonym.Name) > 255 ||
+					synonym.Language != "" && !languagePattern.MatchString(synonym.Language) {
+					return false
+				}
+			}
+			return true
+		}},
+}
+
+// Проверка полей продукта
+func (p *Product) Validate() ValidationErrors {
+	return Check(p, productRules)
+}
+
+// Проверка полей производителя
+func (m *Manufacturer) Validate() ValidationErrors {
+	return Check(m, manufacturerRules)
+}
+
+// Проверка полей ингредиента
+func (s *Structure) Validate() ValidationErrors {
+	return Check(s, structureRules)
+}
+
+// Проверка полей пользователя
+func (u *User) Validate() ValidationErrors {
+	return Check(u, userRules)
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ошибка использования несуществующего, отозванного или истекшего API-ключа
+var ErrAPIKeyInvalid = errors.New("API-ключ недействителен, отозван или истек")
+
+// префикс открытых ключей, чтобы их было легко распознать в конфигурации и логах
+const apiKeyPrefix = "ck_"
+
+// формат дня учета использования ключей
+const usageDayLayout = "2006-01-02"
+
+type APIKeyRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{DB: db}
+}
+
+// столбцы ключа в порядке сканирования scanAPIKey
+const apiKeyColumns = "id, name, prefix, scopes, daily_quota, created_by, created_at, expires_at, revoked_at, last_used_at"
+
+// сканирование строки результата в структуру ключа; extra - дополнительные столбцы после apiKeyColumns
+func scanAPIKey(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	var expiresAt, revokedAt, lastUsedAt sql.NullTime
+	dest := []interface{}{&key.ID, &key.Name, &key.Prefix, &scopes, &key.DailyQuota, &key.CreatedBy, &key.CreatedAt, &expiresAt, &revokedAt, &lastUsedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	for _, scope := range strings.Fields(scopes) {
+		key.Scopes = append(key.Scopes, models.Permission(scope))
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
+
+// создание ключа; открытое значение записывается в key.Key и в БД не хранится
+func (r *APIKeyRepository) Create(key *models.APIKey) error {
+	secret, err := newSecret(32)
+	if err != nil {
+		return err
+	}
+	raw := apiKeyPrefix + secret
+	key.Prefix = raw[:len(apiKeyPrefix)+6]
+	key.CreatedAt = dbTime(time.Now())
+	var expiresAt interface{}
+	if key.ExpiresAt != nil {
+		t := dbTime(*key.ExpiresAt)
+		key.ExpiresAt = &t
+		expiresAt = t
+	}
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	result, err := r.DB.Exec(
+		"INSERT INTO api_keys (name, key_hash, prefix, scopes, daily_quota, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		key.Name, hashSecret(raw), key.Prefix, strings.Join(scopes, " "), key.DailyQuota, key.CreatedBy, key.CreatedAt, expiresAt)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	key.ID = int(id)
+	key.Key = raw
+	return nil
+}
+
+// получение всех ключей с числом запросов за текущие сутки
+func (r *APIKeyRepository) GetAll() ([]models.APIKey, error) {
+	rows, err := r.DB.Query("SELECT "+apiKeyColumns+", COALESCE(u.count, 0) FROM api_keys k "+
+		"LEFT JOIN api_key_usage u ON u.key_id = k.id AND u.day = ? ORDER BY k.id DESC",
+		time.Now().UTC().Format(usageDayLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var usedToday int
+		key, err := scanAPIKey(rows, &usedToday)
+		if err != nil {
+			return nil, err
+		}
+		key.UsedToday = usedToday
+		keys = append(keys, *key)
+	}
+	return keys, nil
+}
+
+// получение ключа по id; nil, если ключа нет
+func (r *APIKeyRepository) GetByID(id int) (*models.APIKey, error) {
+	key, err := scanAPIKey(r.DB.QueryRow("SELECT "+apiKeyColumns+" FROM api_keys WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// отзыв ключа
+func (r *APIKeyRepository) Revoke(id int) error {
+	_, err := r.DB.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", dbTime(time.Now()), id)
+	return err
+}
+
+// проверка открытого ключа и учет запроса; возвращает ключ с числом запросов за сутки,
+// включая текущий, чтобы вызывающий мог сравнить его с квотой
+func (r *APIKeyRepository) Authenticate(raw string) (*models.APIKey, error) {
+	if !strings.HasPrefix(raw, apiKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+	now := dbTime(time.Now())
+	key, err := scanAPIKey(r.DB.QueryRow(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+		hashSecret(raw), now))
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	day := now.Format(usageDayLayout)
+	if _, err := tx.Exec(
+		"INSERT INTO api_key_usage (key_id, day, count) VALUES (?, ?, 1) ON CONFLICT (key_id, day) DO UPDATE SET count = count + 1",
+		key.ID, day); err != nil {
+		return nil, err
+	}
+	if err := tx.QueryRow("SELECT count FROM api_key_usage WHERE key_id = ? AND day = ?", key.ID, day).Scan(&key.UsedToday); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, key.ID); err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &now
+	return key, tx.Commit()
+}
+
+// число запросов по ключу за последние days суток, начиная с самых свежих
+func (r *APIKeyRepository) Usage(id, days int) ([]models.APIKeyUsage, error) {
+	since := time.Now().UTC().AddDate(0, 0, -days+1).Format(usageDayLayout)
+	rows, err := r.DB.Query("SELECT day, count FROM api_key_usage WHERE key_id = ? AND day >= ? ORDER BY day DESC", id, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []models.APIKeyUsage{}
+	for rows.Next() {
+		var u models.APIKeyUsage
+		if err := rows.Scan(&u.Day, &u.Count); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type AuditRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{DB: db}
+}
+
+// фильтры журнала аудита; пустые поля не ограничивают выборку
+type AuditFilter struct {
+	Actor      string
+	Action     models.AuditAction
+	EntityType string
+	EntityID   int
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// запись в журнал; состояния до и после передаются уже в виде JSON
+func (r *AuditRepository) Record(entry *models.AuditEntry) error {
+	entry.CreatedAt = dbTime(time.Now())
+	result, err := r.DB.Exec(
+		"INSERT INTO audit_log (actor, action, entity_type, entity_id, before_json, after_json, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.Actor, entry.Action, entry.EntityType, entry.EntityID, nullJSON(entry.Before), nullJSON(entry.After), entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	entry.ID = int(id)
+	return nil
+}
+
+// получение записей журнала по фильтру, начиная с самых новых
+func (r *AuditRepository) List(filter AuditFilter) ([]models.AuditEntry, error) {
+	query := "SELECT id, actor, action, entity_type, entity_id, before_json, after_json, created_at FROM audit_log"
+	var where []string
+	var args []interface{}
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.EntityType != "" {
+		where = append(where, "entity_type = ?")
+		args = append(args, filter.EntityType)
+	}
+	if filter.EntityID > 0 {
+		where = append(where, "entity_id = ?")
+		args = append(args, filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, dbTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, dbTime(filter.To))
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.EntityType, &e.EntityID, &before, &after, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			e.Before = []byte(before.String)
+		}
+		if after.Valid {
+			e.After = []byte(after.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// пустое состояние сохраняется как NULL
+func nullJSON(data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ошибки изменения семейств ингредиентов
+var (
+	ErrFamilyExists      = errors.New("семейство с таким названием уже существует")
+	ErrFamilyNotFound    = errors.New("семейство не найдено")
+	ErrFamilyCycle       = errors.New("семейство нельзя вложить в само себя или в своего потомка")
+	ErrFamilyHasChildren = errors.New("у семейства есть вложенные семейства")
+)
+
+// префикс ссылки на семейство в фильтрах ингредиентов: family:12 или family:парабены
+const familyRefPrefix = "family:"
+
+// Id семейств, выбранных условием where (корни), и всех их потомков
+// UNION вместо UNION ALL не дает зациклиться рекурсии
+const familyTreeSQL = `WITH RECURSIVE family_tree (family_id) AS (
+		SELECT family_id FROM ingredient_family WHERE %s
+		UNION SELECT f.family_id FROM ingredient_family f JOIN family_tree t ON f.parent_id = t.family_id
+	) SELECT family_id FROM family_tree`
+
+// Семейства с числом ингредиентов и продуктов по семейству и всем его потомкам;
+// closure - пары (предок, семейство), включая само семейство
+const familySelectSQL = `WITH RECURSIVE closure (ancestor, family_id) AS (
+		SELECT family_id, family_id FROM ingredient_family
+		UNION SELECT c.ancestor, f.family_id FROM ingredient_family f JOIN closure c ON f.parent_id = c.family_id
+	)
+	SELECT f.family_id, f.family_name, f.parent_id,
+		(SELECT COUNT(DISTINCT sf.structure_id) FROM closure c JOIN structure_family sf ON sf.family_id = c.family_id
+			WHERE c.ancestor = f.family_id),
+		(SELECT COUNT(DISTINCT ps.product_id) FROM closure c JOIN structure_family sf ON sf.family_id = c.family_id
+			JOIN product_structure ps ON ps.structure_id = sf.structure_id WHERE c.ancestor = f.family_id)
+	FROM ingredient_family f`
+
+type FamilyRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewFamilyRepository(db *sql.DB) *FamilyRepository {
+	return &FamilyRepository{DB: db}
+}
+
+// Условие на столбец id ингредиента column для ссылки на семейство (id или название без учета регистра
+// и транслитерации): ингредиенты семейства и всех его потомков
+func familyCondition(column, ref string) (string, interface{}) {
+	roots, arg := "search_key(family_name) = ?", interface{}(models.SearchKey(ref))
+	if id, err := strconv.Atoi(ref); err == nil {
+		roots, arg = "family_id = ?", id
+	}
+	return column + " IN (SELECT structure_id FROM structure_family WHERE family_id IN (" + fmt.Sprintf(familyTreeSQL, roots) + "))", arg
+}
+
+// выборка семейств с условием where по f
+func queryFamilies(db *sql.DB, where string, args ...interface{}) ([]models.IngredientFamily, error) {
+	query := familySelectSQL
+	if where != "" {
+		query += " WHERE " + where
+	}
+	rows, err := db.Query(query+" ORDER BY f.family_name COLLATE NOCASE", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	families := []models.IngredientFamily{}
+	for rows.Next() {
+		var f models.IngredientFamily
+		var parentID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.Name, &parentID, &f.StructureCount, &f.ProductCount); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			f.ParentID = &id
+		}
+		families = append(families, f)
+	}
+	return families, rows.Err()
+}
+
+// все семейства списком по названию
+func (r *FamilyRepository) GetAll() ([]models.IngredientFamily, error) {
+	return queryFamilies(r.DB, "")
+}
+
+// Дерево семейств: корни с вложенными дочерними семействами; порядок списка сохраняется
+func FamilyTree(families []models.IngredientFamily) []models.IngredientFamily {
+	children := map[int][]models.IngredientFamily{}
+	known := make(map[int]bool, len(families))
+	for _, f := range families {
+		known[f.ID] = true
+	}
+	var roots []models.IngredientFamily
+	for _, f := range families {
+		if f.ParentID != nil && known[*f.ParentID] {
+			children[*f.ParentID] = append(children[*f.ParentID], f)
+		} else {
+			roots = append(roots, f)
+		}
+	}
+	var build func(list []models.IngredientFamily) []models.IngredientFamily
+	build = func(list []models.IngredientFamily) []models.IngredientFamily {
+		for i := range list {
+			list[i].Children = build(children[list[i].ID])
+		}
+		return list
+	}
+	return build(roots)
+}
+
+// получение семейства по id с дочерними семействами; nil, если семейства нет
+func (r *FamilyRepository) GetByID(id int) (*models.IngredientFamily, error) {
+	families, err := queryFamilies(r.DB, "f.family_id = ?", id)
+	if err != nil || len(families) == 0 {
+		return nil, err
+	}
+	family := families[0]
+	if family.Children, err = queryFamilies(r.DB, "f.parent_id = ?", id); err != nil {
+		return nil, err
+	}
+	return &family, nil
+}
+
+// семейства, в которые ингредиент входит напрямую
+func (r *FamilyRepository) ForStructure(structureID int) ([]models.IngredientFamily, error) {
+	return queryFamilies(r.DB, "f.family_id IN (SELECT family_id FROM structure_family WHERE structure_id = ?)", structureID)
+}
+
+// Проверка названия и родителя семейства перед записью; названия сравниваются по поисковому ключу,
+// как в фильтре family, чтобы ссылка по названию указывала на одно семейство
+// Выполняется в транзакции записи, чтобы параллельный запрос не прошел ту же проверку до записи
+func checkFamily(q rowQuerier, family *models.IngredientFamily) error {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM ingredient_family WHERE search_key(family_name) = ? AND family_id <> ?)",
+		models.SearchKey(family.Name), family.ID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrFamilyExists
+	}
+	if family.ParentID == nil {
+		return nil
+	}
+	if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM ingredient_family WHERE family_id = ?)", *family.ParentID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: родительское семейство %d", ErrFamilyNotFound, *family.ParentID)
+	}
+	//новый родитель не должен быть самим семейством или его потомком
+	if family.ID > 0 {
+		err := q.QueryRow("SELECT ? IN ("+fmt.Sprintf(familyTreeSQL, "family_id = ?")+")", *family.ParentID, family.ID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrFamilyCycle
+		}
+	}
+	return nil
+}
+
+// добавление семейства
+func (r *FamilyRepository) Create(family *models.IngredientFamily) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkFamily(tx, family); err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO ingredient_family (family_name, parent_id) VALUES (?, ?)", family.Name, family.ParentID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	family.ID = int(id)
+	return nil
+}
+
+// переименование и перенос семейства в другое родительское (nil - в корень)
+func (r *FamilyRepository) Update(family *models.IngredientFamily) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkFamily(tx, family); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE ingredient_family SET family_name = ?, parent_id = ? WHERE family_id = ?", family.Name, family.ParentID, family.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// удаление семейства без вложенных семейств вместе с принадлежностью ему ингредиентов
+func (r *FamilyRepository) Delete(id int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hasChildren bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM ingredient_family WHERE parent_id = ?)", id).Scan(&hasChildren); err != nil {
+		return err
+	}
+	if hasChildren {
+		return ErrFamilyHasChildren
+	}
+	if _, err := tx.Exec("DELETE FROM structure_family WHERE family_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM ingredient_family WHERE family_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Ингредиенты семейства с числом продуктов; descendants - вместе с ингредиентами всех потомков
+func (r *FamilyRepository) Structures(familyID int, descendants bool) ([]models.Structure, error) {
+	families := "family_id = ?"
+	if descendants {
+		families = "family_id IN (" + fmt.Sprintf(familyTreeSQL, "family_id = ?") + ")"
+	}
+	rows, err := r.DB.Query(
+		"SELECT s.structure_id, s.structure_name, (SELECT COUNT(*) FROM product_structure ps WHERE ps.structure_id = s.structure_id) "+
+			"FROM structure s WHERE s.structure_id IN (SELECT structure_id FROM structure_family WHERE "+families+") "+
+			"ORDER BY s.structure_name COLLATE NOCASE", familyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	structures := []models.Structure{}
+	for rows.Next() {
+		var s models.Structure
+		if err := rows.Scan(&s.ID, &s.Name, &s.ProductCount); err != nil {
+			return nil, err
+		}
+		structures = append(structures, s)
+	}
+	return structures, rows.Err()
+}
+
+// замена всех ингредиентов семейства (без потомков) одной транзакцией
+func (r *FamilyRepository) SetStructures(familyID int, structureIDs []int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM structure_family WHERE family_id = ?", familyID); err != nil {
+		return err
+	}
+	if err := linkFamily(tx, familyID, structureIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// добавление ингредиентов в семейство; уже входящие пропускаются
+func (r *FamilyRepository) AddStructures(familyID int, structureIDs []int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := linkFamily(tx, familyID, structureIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// исключение ингредиента из семейства; false, если он не входил в семейство напрямую
+func (r *FamilyRepository) RemoveStructure(familyID, structureID int) (bool, error) {
+	result, err := r.DB.Exec("DELETE FROM structure_family WHERE family_id = ? AND structure_id = ?", familyID, structureID)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// вставка принадлежности ингредиентов семейству внутри транзакции
+func linkFamily(tx *sql.Tx, familyID int, structureIDs []int) error {
+	for _, id := range structureIDs {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO structure_family (structure_id, family_id) VALUES (?, ?)", id, familyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Привязка внешних учетных записей (issuer + subject провайдера OpenID Connect) к пользователям
+type IdentityRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{DB: db}
+}
+
+// Id пользователя, к которому привязана внешняя учетная запись (0, если привязки нет),
+// и признак, что пользователь создан при входе через провайдера
+func (r *IdentityRepository) GetUserID(issuer, subject string) (int, bool, error) {
+	var userID int
+	var created bool
+	err := r.DB.QueryRow("SELECT user_id, created_user FROM user_identities WHERE issuer = ? AND subject = ?", issuer, subject).Scan(&userID, &created)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return userID, created, err
+}
+
+// привязка внешней учетной записи к существующему пользователю
+func (r *IdentityRepository) Link(userID int, issuer, subject string) error {
+	now := dbTime(time.Now())
+	_, err := r.DB.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject, created_at, last_login_at) VALUES (?, ?, ?, ?, ?)",
+		userID, issuer, subject, now, now)
+	return err
+}
+
+// создание пользователя вместе с привязкой внешней учетной записи
+func (r *IdentityRepository) CreateUser(user *models.User, issuer, subject string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO users (username, password, role) VALUES (?, ?, ?)", user.UserName, user.Password, user.Role)
+	if err != nil {
+		log.Printf("DB Error (CreateUser via OIDC): %v", err)
+		return fmt.Errorf("ошибка при создании пользователя: %w", err)
+	}
+	id, _ := result.LastInsertId()
+	user.ID = int(id)
+
+	now := dbTime(time.Now())
+	if _, err := tx.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject, created_at, last_login_at, created_user) VALUES (?, ?, ?, ?, ?, 1)",
+		user.ID, issuer, subject, now, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// отметка времени входа через внешнюю учетную запись
+func (r *IdentityRepository) Touch(issuer, subject string) error {
+	_, err := r.DB.Exec("UPDATE user_identities SET last_login_at = ? WHERE issuer = ? AND subject = ?", dbTime(time.Now()), issuer, subject)
+	return err
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ошибка использования несуществующего, истекшего или уже использованного приглашения
+var ErrInviteInvalid = errors.New("приглашение недействительно или истекло")
+
+type InviteRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewInviteRepository(db *sql.DB) *InviteRepository {
+	return &InviteRepository{DB: db}
+}
+
+// создание приглашения; открытый код записывается в invite.Code и в БД не хранится
+func (r *InviteRepository) Create(invite *models.Invite) error {
+	code, err := newSecret(18)
+	if err != nil {
+		return err
+	}
+	invite.CreatedAt = dbTime(time.Now())
+	invite.ExpiresAt = dbTime(invite.ExpiresAt)
+
+	result, err := r.DB.Exec(
+		"INSERT INTO invites (code_hash, role, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		hashSecret(code), invite.Role, invite.CreatedBy, invite.CreatedAt, invite.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	invite.ID = int(id)
+	invite.Code = code
+	return nil
+}
+
+// получение всех приглашений
+func (r *InviteRepository) GetAll() ([]models.Invite, error) {
+	rows, err := r.DB.Query("SELECT id, role, created_by, created_at, expires_at, used_at, used_by FROM invites ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []models.Invite
+	for rows.Next() {
+		var invite models.Invite
+		var usedAt sql.NullTime
+		var usedBy sql.NullInt64
+		if err := rows.Scan(&invite.ID, &invite.Role, &invite.CreatedBy, &invite.CreatedAt, &invite.ExpiresAt, &usedAt, &usedBy); err != nil {
+			return nil, err
+		}
+		if usedAt.Valid {
+			invite.UsedAt = &usedAt.Time
+		}
+		if usedBy.Valid {
+			id := int(usedBy.Int64)
+			invite.UsedBy = &id
+		}
+		invites = append(invites, invite)
+	}
+	return invites, nil
+}
+
+// удаление (отзыв) приглашения
+func (r *InviteRepository) Delete(id int) error {
+	_, err := r.DB.Exec("DELETE FROM invites WHERE id = ?", id)
+	return err
+}
+
+// регистрация пользователя по приглашению в одной транзакции:
+// приглашение погашается, пользователь получает роль из приглашения
+func (r *InviteRepository) RegisterWithInvite(code string, user *models.User) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := dbTime(time.Now())
+	var inviteID int
+	err = tx.QueryRow(
+		"SELECT id, role FROM invites WHERE code_hash = ? AND used_at IS NULL AND expires_at > ?",
+		hashSecret(code), now).Scan(&inviteID, &user.Role)
+	if err == sql.ErrNoRows {
+		return ErrInviteInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO users (username, password, role) VALUES (?, ?, ?)", user.UserName, user.Password, user.Role)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	user.ID = int(id)
+
+	//повторная проверка used_at защищает от одновременного использования одного кода
+	result, err = tx.Exec("UPDATE invites SET used_at = ?, used_by = ? WHERE id = ? AND used_at IS NULL", now, user.ID, inviteID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInviteInvalid
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+type LoginAttemptRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{DB: db}
+}
+
+// запись попытки входа
+func (r *LoginAttemptRepository) Record(username, ip string, success bool) error {
+	_, err := r.DB.Exec("INSERT INTO login_attempts (username, ip, success, created_at) VALUES (?, ?, ?, ?)",
+		username, ip, success, dbTime(time.Now()))
+	return err
+}
+
+// число неудачных попыток для логина с момента since и время последней из них
+func (r *LoginAttemptRepository) UsernameFailures(username string, since time.Time) (int, time.Time, error) {
+	return r.failures("username", username, since)
+}
+
+// число неудачных попыток с IP-адреса с момента since и время последней из них
+func (r *LoginAttemptRepository) IPFailures(ip string, since time.Time) (int, time.Time, error) {
+	return r.failures("ip", ip, since)
+}
+
+// подсчет неудачных попыток по столбцу username или ip
+func (r *LoginAttemptRepository) failures(column, value string, since time.Time) (int, time.Time, error) {
+	var count int
+	var last time.Time
+	err := r.DB.QueryRow(
+		"SELECT COUNT(*) FROM login_attempts WHERE "+column+" = ? AND success = 0 AND created_at >= ?",
+		value, dbTime(since)).Scan(&count)
+	if err != nil || count == 0 {
+		return count, last, err
+	}
+	err = r.DB.QueryRow(
+		"SELECT created_at FROM login_attempts WHERE "+column+" = ? AND success = 0 ORDER BY created_at DESC LIMIT 1",
+		value).Scan(&last)
+	return count, last, err
+}
+
+// получение журнала попыток входа с необязательными фильтрами по логину и IP
+func (r *LoginAttemptRepository) List(username, ip string, limit int) ([]models.LoginAttempt, error) {
+	query := "SELECT id, username, ip, success, created_at FROM login_attempts"
+	var where []string
+	var args []interface{}
+	if username != "" {
+		where = append(where, "username = ?")
+		args = append(args, username)
+	}
+	if ip != "" {
+		where = append(where, "ip = ?")
+		args = append(args, ip)
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var a models.LoginAttempt
+		if err := rows.Scan(&a.ID, &a.UserName, &a.IP, &a.Success, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"cosmetics/models"
 	"database/sql"
+	"strings"
 )
 
 type ManufacturerRepository struct {
@@ -71,3 +72,76 @@ func (r *ManufacturerRepository) Delete(id int) error {
 	_, err := r.DB.Exec("DELETE FROM manufacturer WHERE manufacturer_id = ?", id)
 	return err
 }
+
+// число продуктов производителя
+func (r *ManufacturerRepository) CountProducts(id int) (int, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM products WHERE manufacturer_id = ?", id).Scan(&count)
+	return count, err
+}
+
+// фильтры списка производителей; пустые значения не ограничивают выборку
+type ManufacturerFilter struct {
+	Country string
+	Query   string // часть названия без учета регистра, ё/е и транслитерации
+}
+
+// допустимые ключи сортировки производителей и соответствующие выражения SQL
+var manufacturerSorts = map[string]string{
+	"id":      "manufacturer_id",
+	"title":   "manufacturer_title COLLATE NOCASE",
+	"country": "country COLLATE NOCASE",
+}
+
+// страница производителей по фильтру
+func (r *ManufacturerRepository) List(filter ManufacturerFilter, page Page) ([]models.Manufacturer, PageInfo, error) {
+	sortExpr, ok := manufacturerSorts[page.Sort]
+	if !ok {
+		return nil, PageInfo{}, ErrInvalidSort
+	}
+	var where []string
+	var args []interface{}
+	if filter.Country != "" {
+		where = append(where, "search_key(country) = ?")
+		args = append(args, models.SearchKey(filter.Country))
+	}
+	if filter.Query != "" {
+		where = append(where, "search_key(manufacturer_title) LIKE ? ESCAPE '\\'")
+		args = append(args, likeContains(models.SearchKey(filter.Query)))
+	}
+
+	countQuery := "SELECT COUNT(*) FROM manufacturer"
+	if len(where) > 0 {
+		countQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := r.DB.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	query, queryArgs := pageSQL(
+		"SELECT "+sortExpr+", manufacturer_id, manufacturer_title, country, address, contact_list FROM manufacturer",
+		where, args, sortExpr, "manufacturer_id", page)
+	rows, err := r.DB.Query(query, queryArgs...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+
+	manufacturers := []models.Manufacturer{}
+	var keys []Cursor
+	for rows.Next() {
+		var manufacturer models.Manufacturer
+		var sortValue interface{}
+		if err := rows.Scan(&sortValue, &manufacturer.ID, &manufacturer.Title, &manufacturer.Country, &manufacturer.Address, &manufacturer.ContactList); err != nil {
+			return nil, PageInfo{}, err
+		}
+		manufacturers = append(manufacturers, manufacturer)
+		keys = append(keys, Cursor{Value: cursorValue(sortValue), ID: manufacturer.ID})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	manufacturers, info := finishPage(page, manufacturers, keys, total)
+	return manufacturers, info, nil
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ошибки параметров страницы
+var (
+	ErrInvalidCursor = errors.New("неверный курсор")
+	ErrInvalidSort   = errors.New("неверный ключ сортировки")
+)
+
+// Параметры страницы списка
+// При заданном Cursor выбирается страница после (или перед) записью курсора, Offset не используется
+type Page struct {
+	Sort   string // ключ сортировки из допустимых для списка
+	Desc   bool
+	Limit  int
+	Offset int
+	Cursor *Cursor
+}
+
+// Позиция в списке: сортировка, значение поля сортировки и id записи на границе страницы
+// Before - страница перед этой записью, иначе после нее
+type Cursor struct {
+	Sort   string      `json:"s"`
+	Desc   bool        `json:"d,omitempty"`
+	Value  interface{} `json:"v"`
+	ID     int         `json:"i"`
+	Before bool        `json:"b,omitempty"`
+}
+
+// непрозрачная строка курсора для параметра cursor
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// разбор строки курсора
+func DecodeCursor(value string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort == "" || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// сведения о выбранной странице; First и Last - курсоры на соседние страницы (nil для пустой страницы)
+type PageInfo struct {
+	Total   int
+	HasNext bool
+	HasPrev bool
+	First   *Cursor
+	Last    *Cursor
+}
+
+// Запрос страницы: к selectSQL (SELECT ... FROM ...) добавляются условия where, условие курсора,
+// ORDER BY по sortExpr и idExpr и LIMIT на одну запись больше страницы, чтобы узнать о следующей
+// Первыми двумя столбцами selectSQL должны быть значение сортировки и id записи
+func pageSQL(selectSQL string, where []string, args []interface{}, sortExpr, idExpr string, page Page) (string, []interface{}) {
+	where = where[:len(where):len(where)]
+	args = args[:len(args):len(args)]
+	desc := page.Desc
+	if c := page.Cursor; c != nil {
+		//страница назад выбирается в обратном порядке и затем переворачивается
+		if c.Before {
+			desc = !desc
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", sortExpr, op, sortExpr, idExpr, op))
+		args = append(args, c.Value, c.Value, c.ID)
+	}
+	query := selectSQL
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, %s %s LIMIT ?", sortExpr, dir, idExpr, dir)
+	args = append(args, page.Limit+1)
+	if page.Cursor == nil && page.Offset > 0 {
+		query += " OFFSET ?"
+		args = append(args, page.Offset)
+	}
+	return query, args
+}
+
+// Обрезка лишней записи, восстановление порядка страницы назад и сведения о соседних страницах
+// keys - курсоры выбранных записей (значение сортировки и id) в порядке выборки; nil для списков без курсоров
+func finishPage[T any](page Page, items []T, keys []Cursor, total int) ([]T, PageInfo) {
+	info := PageInfo{Total: total}
+	more := len(items) > page.Limit
+	if more {
+		items = items[:page.Limit]
+	}
+	if len(keys) > page.Limit {
+		keys = keys[:page.Limit]
+	}
+	switch {
+	case page.Cursor != nil && page.Cursor.Before:
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+		info.HasPrev, info.HasNext = more, true
+	case page.Cursor != nil:
+		info.HasPrev, info.HasNext = true, more
+	default:
+		info.HasPrev, info.HasNext = page.Offset > 0, more
+	}
+	if len(keys) > 0 {
+		first, last := keys[0], keys[len(keys)-1]
+		first.Sort, first.Desc, first.Before = page.Sort, page.Desc, true
+		last.Sort, last.Desc = page.Sort, page.Desc
+		info.First, info.Last = &first, &last
+	}
+	return items, info
+}
+
+// значение сортировки из БД в виде, пригодном для курсора
+func cursorValue(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ошибка использования несуществующего, истекшего или уже использованного токена сброса
+var ErrResetTokenInvalid = errors.New("токен сброса пароля недействителен или истек")
+
+type PasswordResetRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{DB: db}
+}
+
+// создание токена сброса пароля; прежние неиспользованные токены пользователя аннулируются
+func (r *PasswordResetRepository) Create(userID int, createdBy string, ttl time.Duration) (string, time.Time, error) {
+	token, err := newSecret(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := dbTime(time.Now())
+	expiresAt := now.Add(ttl)
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userID); err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO password_resets (user_id, token_hash, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		userID, hashSecret(token), createdBy, now, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, tx.Commit()
+}
+
+// погашение токена сброса; возвращает id пользователя
+func (r *PasswordResetRepository) Consume(token string) (int, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := dbTime(time.Now())
+	var id, userID int
+	err = tx.QueryRow(
+		"SELECT id, user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?",
+		hashSecret(token), now).Scan(&id, &userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL", now, id)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, ErrResetTokenInvalid
+	}
+	return userID, tx.Commit()
+}
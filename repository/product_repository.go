@@ -3,13 +3,20 @@ package repository
 import (
 	"cosmetics/models"
 	"database/sql"
-	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type ProductRepository struct {
-	DB *sql.DB
+	DB       *sql.DB
+	FullText bool // доступен полнотекстовый индекс products_fts (FTS5)
+
+	// кэш словаря исправления опечаток и версия каталога (catalog_version), для которой он собран
+	vocabularyMu      sync.Mutex
+	vocabularyCache   map[string]*vocabularyWord
+	vocabularyVersion int64
 }
 
 // конструктор с подключением
@@ -17,14 +24,28 @@ func NewProductRepository(db *sql.DB) *ProductRepository {
 	return &ProductRepository{DB: db}
 }
 
-// добавление нового продукта
+// добавление нового продукта вместе с составом из product.Structures одной транзакцией
 func (r *ProductRepository) Create(product *models.Product) error {
-	result, err := r.DB.Exec(`INSERT INTO products (product_title, product_description,contraindications, application, volume, manufacturer_id, photo) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO products (product_title, product_description,contraindications, application, volume, manufacturer_id, photo) VALUES (?, ?, ?, ?, ?, ?, ?)`,
 		product.Title, product.Description, product.Contraindications, product.Application, product.Volume, product.ManufacturerID, product.Photo)
 	if err != nil {
 		return err
 	}
 	id, _ := result.LastInsertId()
+	if product.Structures != nil {
+		if err := replaceStructures(tx, int(id), product.Structures); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	product.ID = int(id)
 	return nil
 }
@@ -45,21 +66,9 @@ func (r *ProductRepository) GetByID(id int) (*models.Product, error) {
 	manufacturer, _ := NewManufacturerRepository(r.DB).GetByID(product.ManufacturerID)
 	product.Manufacturer = manufacturer
 
-	structureRows, err := r.DB.Query(`SELECT s.structure_id, s.structure_name FROM structure s JOIN product_structure ps ON ps.structure_id = s.structure_id WHERE ps.product_id = ?`, id)
-	if err != nil {
+	if product.Structures, err = NewStructureRepository(r.DB).ForProduct(id); err != nil {
 		return nil, err
 	}
-	defer structureRows.Close()
-
-	var structures []models.Structure
-	for structureRows.Next() {
-		var s models.Structure
-		if err := structureRows.Scan(&s.ID, &s.Name); err != nil {
-			return nil, err
-		}
-		structures = append(structures, s)
-	}
-	product.Structures = structures
 	return &product, nil
 }
 
@@ -85,92 +94,209 @@ func (r *ProductRepository) GetAll() ([]models.Product, error) {
 		manufacturer, _ := NewManufacturerRepository(r.DB).GetByID(product.ManufacturerID)
 		product.Manufacturer = manufacturer
 
-		structureRows, err := r.DB.Query(`SELECT s.structure_id, s.structure_name FROM structure s JOIN product_structure ps ON ps.structure_id = s.structure_id WHERE ps.product_id = ?`, product.ID)
-		if err != nil {
+		if product.Structures, err = NewStructureRepository(r.DB).ForProduct(product.ID); err != nil {
 			return nil, err
 		}
-		defer structureRows.Close()
-
-		var structures []models.Structure
-		for structureRows.Next() {
-			var s models.Structure
-			if err := structureRows.Scan(&s.ID, &s.Name); err != nil {
-				return nil, err
-			}
-			structures = append(structures, s)
-		}
-		product.Structures = structures
 
 		products = append(products, product)
 	}
 	return products, nil
 }
 
-// обновление продукта
+// обновление продукта; состав заменяется, только если product.Structures не nil
 func (r *ProductRepository) Update(product *models.Product) error {
-	_, err := r.DB.Exec(`UPDATE products SET product_title = ?, product_description = ?, contraindications = ?, application = ?, volume = ?, photo = ?, manufacturer_id = ? WHERE product_id = ?`,
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE products SET product_title = ?, product_description = ?, contraindications = ?, application = ?, volume = ?, photo = ?, manufacturer_id = ? WHERE product_id = ?`,
 		product.Title, product.Description, product.Contraindications, product.Application, product.Volume, product.Photo, product.ManufacturerID, product.ID)
-	return err
+	if err != nil {
+		return err
+	}
+	if product.Structures != nil {
+		if err := replaceStructures(tx, product.ID, product.Structures); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-// удаление продукта
+// удаление продукта вместе с его составом
 func (r *ProductRepository) Delete(id int) error {
-	_, err := r.DB.Exec("DELETE FROM products WHERE product_id = ?", id)
-	return err
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM product_structure WHERE product_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM product_views WHERE product_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM products WHERE product_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// получение продукта по заданным требованиями(по названию, по производителю)
-func (r *ProductRepository) GetProductsSearch(manufacturerID int, searchQuery string) ([]models.Product, error) {
-	var products []models.Product
+// фильтры списка продуктов; нулевые значения не ограничивают выборку
+type ProductFilter struct {
+	ManufacturerID int
+	Country        string // страна производителя без учета регистра
+	MinVolume      float64
+	MaxVolume      float64
+	Query          string // часть названия без учета регистра, ё/е и транслитерации
+	// ингредиенты по id или части названия (по поисковому ключу) и семейства (family:<id или название>):
+	// продукт содержит каждый из WithIngredients и ни одного из WithoutIngredients
+	WithIngredients    []string
+	WithoutIngredients []string
+}
+
+// допустимые ключи сортировки продуктов и соответствующие выражения SQL
+var productSorts = map[string]string{
+	"id":           "p.product_id",
+	"title":        "p.product_title COLLATE NOCASE",
+	"volume":       "p.volume",
+	"manufacturer": "COALESCE(m.manufacturer_title, '') COLLATE NOCASE",
+}
+
+// условия выборки продуктов по фильтру
+func (f ProductFilter) where() ([]string, []interface{}) {
+	var where []string
 	var args []interface{}
-	argCount := 0
-	query := `
-        SELECT p.product_id, p.product_title, p.product_description, p.contraindications, p.application, p.volume, p.photo, p.manufacturer_id,
-               m.manufacturer_id, m.manufacturer_title
-        FROM products p
-        JOIN manufacturer m ON p.manufacturer_id = m.manufacturer_id
-    `
-	whereClauses := []string{}
-	if manufacturerID > 0 {
-		argCount++
-		whereClauses = append(whereClauses, fmt.Sprintf("p.manufacturer_id = $%d", argCount))
-		args = append(args, manufacturerID)
-	}
-	if searchQuery != "" {
-		argCount++
-		whereClauses = append(whereClauses, fmt.Sprintf("p.product_title LIKE $%d", argCount))
-		args = append(args, "%"+searchQuery+"%")
-	}
-	if len(whereClauses) > 0 {
-		query += " WHERE " + strings.Join(whereClauses, " AND ")
-	}
-	query += " ORDER BY p.product_id ASC"
-	rows, err := r.DB.Query(query, args...)
+	if f.ManufacturerID > 0 {
+		where = append(where, "p.manufacturer_id = ?")
+		args = append(args, f.ManufacturerID)
+	}
+	if f.Country != "" {
+		where = append(where, "search_key(m.country) = ?")
+		args = append(args, models.SearchKey(f.Country))
+	}
+	if f.MinVolume > 0 {
+		where = append(where, "p.volume >= ?")
+		args = append(args, f.MinVolume)
+	}
+	if f.MaxVolume > 0 {
+		where = append(where, "p.volume <= ?")
+		args = append(args, f.MaxVolume)
+	}
+	if f.Query != "" {
+		where = append(where, "search_key(p.product_title) LIKE ? ESCAPE '\\'")
+		args = append(args, likeContains(models.SearchKey(f.Query)))
+	}
+	for _, ingredient := range f.WithIngredients {
+		cond, condArgs := ingredientCondition(ingredient)
+		where = append(where, "EXISTS (SELECT 1 FROM product_structure ps WHERE ps.product_id = p.product_id AND "+cond+")")
+		args = append(args, condArgs...)
+	}
+	if len(f.WithoutIngredients) > 0 {
+		conds := make([]string, len(f.WithoutIngredients))
+		for i, ingredient := range f.WithoutIngredients {
+			var condArgs []interface{}
+			conds[i], condArgs = ingredientCondition(ingredient)
+			args = append(args, condArgs...)
+		}
+		where = append(where, "NOT EXISTS (SELECT 1 FROM product_structure ps WHERE ps.product_id = p.product_id AND ("+strings.Join(conds, " OR ")+"))")
+	}
+	return where, args
+}
+
+// Условие на ps.structure_id для ингредиента фильтра: число - id ингредиента, family:<id или название> -
+// ингредиенты семейства и его потомков, иначе часть названия, названия INCI или синонима ингредиента;
+// ингредиенты по названию выбираются некоррелированным подзапросом, который SQLite выполняет один раз
+func ingredientCondition(ingredient string) (string, []interface{}) {
+	if ref, ok := strings.CutPrefix(ingredient, familyRefPrefix); ok {
+		cond, arg := familyCondition("ps.structure_id", strings.TrimSpace(ref))
+		return cond, []interface{}{arg}
+	}
+	if id, err := strconv.Atoi(ingredient); err == nil {
+		return "ps.structure_id = ?", []interface{}{id}
+	}
+	pattern := likeContains(models.SearchKey(ingredient))
+	return "ps.structure_id IN (" + structureNameSQL + ")", []interface{}{pattern, pattern, pattern}
+}
+
+// Столбцы производителя из LEFT JOIN manufacturer: у продукта может не остаться записи производителя
+type nullManufacturer struct {
+	ID                                   sql.NullInt64
+	Title, Country, Address, ContactList sql.NullString
+}
+
+// производитель продукта; nil, если записи производителя нет
+func (n nullManufacturer) manufacturer() *models.Manufacturer {
+	if !n.ID.Valid {
+		return nil
+	}
+	return &models.Manufacturer{ID: int(n.ID.Int64), Title: n.Title.String, Country: n.Country.String, Address: n.Address.String, ContactList: n.ContactList.String}
+}
+
+// страница продуктов с производителями и составом по фильтру
+func (r *ProductRepository) List(filter ProductFilter, page Page) ([]models.Product, PageInfo, error) {
+	sortExpr, ok := productSorts[page.Sort]
+	if !ok {
+		return nil, PageInfo{}, ErrInvalidSort
+	}
+	const from = " FROM products p LEFT JOIN manufacturer m ON p.manufacturer_id = m.manufacturer_id"
+	where, args := filter.where()
+
+	countQuery := "SELECT COUNT(*)" + from
+	if len(where) > 0 {
+		countQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	var total int
+	if err := r.DB.QueryRow(countQuery, args...).Scan(&total); err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	query, queryArgs := pageSQL(
+		"SELECT "+sortExpr+", p.product_id, p.product_title, p.product_description, p.contraindications, p.application, p.volume, p.photo, p.manufacturer_id, "+
+			"m.manufacturer_id, m.manufacturer_title, m.country, m.address, m.contact_list"+from,
+		where, args, sortExpr, "p.product_id", page)
+	rows, err := r.DB.Query(query, queryArgs...)
 	if err != nil {
 		log.Printf("Ошибка выполнения запроса с фильтрами: %v", err)
-		return nil, err
+		return nil, PageInfo{}, err
 	}
 	defer rows.Close()
 
+	products := []models.Product{}
+	var keys []Cursor
 	for rows.Next() {
 		var p models.Product
-		var m models.Manufacturer
+		var m nullManufacturer
 		var contraindications sql.NullString
+		var sortValue interface{}
 		err := rows.Scan(
-			&p.ID, &p.Title, &p.Description, &contraindications, &p.Application, &p.Volume, &p.Photo, &p.ManufacturerID,
-			&m.ID, &m.Title,
+			&sortValue, &p.ID, &p.Title, &p.Description, &contraindications, &p.Application, &p.Volume, &p.Photo, &p.ManufacturerID,
+			&m.ID, &m.Title, &m.Country, &m.Address, &m.ContactList,
 		)
 		if err != nil {
 			log.Printf("Ошибка продукта: %v", err)
-			return nil, err
+			return nil, PageInfo{}, err
 		}
-
 		if contraindications.Valid {
 			p.Contraindications = &contraindications.String
 		}
-		p.Manufacturer = &m
+		p.Manufacturer = m.manufacturer()
 		products = append(products, p)
+		keys = append(keys, Cursor{Value: cursorValue(sortValue), ID: p.ID})
 	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	rows.Close()
 
-	return products, nil
+	products, info := finishPage(page, products, keys, total)
+	structures := NewStructureRepository(r.DB)
+	for i := range products {
+		if products[i].Structures, err = structures.ForProduct(products[i].ID); err != nil {
+			return nil, PageInfo{}, err
+		}
+	}
+	return products, info, nil
 }
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// пустая строка поиска
+var ErrEmptySearch = errors.New("пустой поисковый запрос")
+
+// Веса столбцов products_fts в bm25: название, описание, применение, противопоказания, производитель, состав
+// и ключи названий продукта и производителя
+const searchRank = "bm25(products_fts, 10.0, 2.0, 1.0, 1.0, 5.0, 3.0, 10.0, 5.0)"
+
+// маркеры совпадений во фрагменте FTS5; заменяются на <mark> после экранирования текста
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// длина фрагмента текста в словах (FTS5) и в символах вокруг совпадения (фрагменты по поисковым ключам)
+const (
+	snippetTokens = 12
+	snippetRunes  = 80
+)
+
+// элемент строки поиска: фраза в кавычках или слово; Prefix - слово со звездочкой в конце
+type searchTerm struct {
+	Text   string
+	Prefix bool
+}
+
+// Разбор строки поиска: "фразы в кавычках" ищутся целиком, слово* - по началу слова,
+// остальные слова - по отдельности; все элементы должны встретиться в продукте
+func parseSearch(input string) []searchTerm {
+	var terms []searchTerm
+	for input = strings.TrimSpace(input); input != ""; input = strings.TrimSpace(input) {
+		if input[0] == '"' {
+			end := strings.IndexByte(input[1:], '"')
+			if end < 0 {
+				end = len(input) - 1
+			}
+			if phrase := strings.Join(strings.Fields(input[1:end+1]), " "); phrase != "" {
+				terms = append(terms, searchTerm{Text: phrase})
+			}
+			input = input[min(end+2, len(input)):]
+			continue
+		}
+		word := input
+		if i := strings.IndexAny(input, " \t\r\n\""); i >= 0 {
+			word = input[:i]
+		}
+		input = input[len(word):]
+		prefix := strings.HasSuffix(word, "*")
+		if word = strings.Trim(word, "*"); word != "" {
+			terms = append(terms, searchTerm{Text: word, Prefix: prefix})
+		}
+	}
+	return terms
+}
+
+// Выражение MATCH для FTS5: элемент ищется в тексте как есть или по поисковому ключу
+// в названиях продукта и производителя; строки в кавычках, чтобы символы синтаксиса FTS5 не ломали запрос
+func matchExpression(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = "(" + ftsString(t.Text, t.Prefix) + " OR {title_key manufacturer_key} : " + ftsString(models.SearchKey(t.Text), t.Prefix) + ")"
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// строка FTS5 в кавычках; prefix - поиск по началу слова
+func ftsString(text string, prefix bool) string {
+	quoted := `"` + strings.ReplaceAll(text, `"`, `""`) + `"`
+	if prefix {
+		quoted += "*"
+	}
+	return quoted
+}
+
+// Экранирование символов шаблона LIKE (\, % и _), чтобы они искались буквально; шаблон сравнивается с ESCAPE '\'
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// шаблон LIKE «содержит s»
+func likeContains(s string) string {
+	return "%" + escapeLike(s) + "%"
+}
+
+// Поиск продуктов по названию, описанию, применению, противопоказаниям, производителю и составу
+// с фильтрами filter (кроме Query) и постраничным выводом по смещению в порядке релевантности
+// Без FTS5 выполняется поиск подстрок поисковых ключей через LIKE, найденные по названию идут первыми
+func (r *ProductRepository) Search(query string, filter ProductFilter, page Page) ([]models.ProductSearchResult, PageInfo, error) {
+	terms := parseSearch(query)
+	if len(terms) == 0 {
+		return nil, PageInfo{}, ErrEmptySearch
+	}
+	if page.Cursor != nil {
+		return nil, PageInfo{}, ErrInvalidCursor
+	}
+	filter.Query = ""
+	where, args := filter.where()
+
+	const columns = "p.product_id, p.product_title, p.product_description, p.contraindications, p.application, p.volume, p.photo, p.manufacturer_id, " +
+		"m.manufacturer_id, m.manufacturer_title, m.country, m.address, m.contact_list"
+	var from, rank, snippet string
+	if r.FullText {
+		from = " FROM products_fts JOIN products p ON p.product_id = products_fts.rowid LEFT JOIN manufacturer m ON p.manufacturer_id = m.manufacturer_id"
+		where = append([]string{"products_fts MATCH ?"}, where...)
+		args = append([]interface{}{matchExpression(terms)}, args...)
+		rank = searchRank
+		snippet = "snippet(products_fts, -1, char(2), char(3), '…', " + strconv.Itoa(snippetTokens) + ")"
+	} else {
+		from = " FROM products p LEFT JOIN manufacturer m ON p.manufacturer_id = m.manufacturer_id"
+		var likeWhere []string
+		var likeArgs []interface{}
+		for _, t := range terms {
+			likeWhere = append(likeWhere, "(search_key(p.product_title) LIKE ? ESCAPE '\\' OR search_key(p.product_description) LIKE ? ESCAPE '\\' "+
+				"OR search_key(p.application) LIKE ? ESCAPE '\\' OR search_key(p.contraindications) LIKE ? ESCAPE '\\' "+
+				"OR search_key(m.manufacturer_title) LIKE ? ESCAPE '\\' "+
+				"OR EXISTS (SELECT 1 FROM product_structure ps WHERE ps.product_id = p.product_id AND ps.structure_id IN ("+structureNameSQL+")))")
+			pattern := likeContains(models.SearchKey(t.Text))
+			for i := 0; i < 8; i++ {
+				likeArgs = append(likeArgs, pattern)
+			}
+		}
+		where = append(likeWhere, where...)
+		args = append(likeArgs, args...)
+		rank = "CASE WHEN search_key(p.product_title) LIKE ? ESCAPE '\\' THEN 0 ELSE 1 END"
+		snippet = "''"
+	}
+	whereSQL := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := r.DB.QueryRow("SELECT COUNT(*)"+from+whereSQL, args...).Scan(&total); err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	queryArgs := args
+	if !r.FullText {
+		queryArgs = append([]interface{}{likeContains(models.SearchKey(terms[0].Text))}, args...)
+	}
+	queryArgs = append(queryArgs, page.Limit+1, page.Offset)
+	rows, err := r.DB.Query("SELECT "+columns+", "+rank+" AS score, "+snippet+from+whereSQL+" ORDER BY score, p.product_id LIMIT ? OFFSET ?", queryArgs...)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	defer rows.Close()
+
+	results := []models.ProductSearchResult{}
+	for rows.Next() {
+		var res models.ProductSearchResult
+		var m nullManufacturer
+		var contraindications sql.NullString
+		var rawSnippet string
+		p := &res.Product
+		err := rows.Scan(
+			&p.ID, &p.Title, &p.Description, &contraindications, &p.Application, &p.Volume, &p.Photo, &p.ManufacturerID,
+			&m.ID, &m.Title, &m.Country, &m.Address, &m.ContactList, &res.Rank, &rawSnippet,
+		)
+		if err != nil {
+			return nil, PageInfo{}, err
+		}
+		if contraindications.Valid {
+			p.Contraindications = &contraindications.String
+		}
+		p.Manufacturer = m.manufacturer()
+		res.Snippet = rawSnippet
+		results = append(results, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, PageInfo{}, err
+	}
+	rows.Close()
+
+	results, info := finishPage(page, results, nil, total)
+	structures := NewStructureRepository(r.DB)
+	for i := range results {
+		p := &results[i].Product
+		if p.Structures, err = structures.ForProduct(p.ID); err != nil {
+			return nil, PageInfo{}, err
+		}
+		results[i].Snippet = productSnippet(*p, results[i].Snippet, r.FullText, terms)
+	}
+	return results, info, nil
+}
+
+// Фрагмент текста результата в HTML
+// Фрагмент FTS5 из столбцов ключей (совпадение только по транслитерации или регистру) заменяется
+// фрагментом исходного текста, как и пустой фрагмент поиска через LIKE
+func productSnippet(p models.Product, raw string, fullText bool, terms []searchTerm) string {
+	if fullText {
+		plain := strings.NewReplacer(markStart, "", markEnd, "", "…", "").Replace(raw)
+		if models.SearchKey(plain) != plain {
+			return markSnippet(raw)
+		}
+	}
+	fields := []string{p.Title, p.Description, p.Application}
+	if p.Contraindications != nil {
+		fields = append(fields, *p.Contraindications)
+	}
+	if p.Manufacturer != nil {
+		fields = append(fields, p.Manufacturer.Title)
+	}
+	names := make([]string, len(p.Structures))
+	for i, s := range p.Structures {
+		names[i] = s.Name
+	}
+	fields = append(fields, strings.Join(names, ", "))
+	if snippet := keySnippet(fields, terms); snippet != "" || !fullText {
+		return snippet
+	}
+	return markSnippet(raw)
+}
+
+// фрагмент FTS5 в HTML: текст экранируется, маркеры совпадений заменяются на <mark>
+func markSnippet(raw string) string {
+	escaped := html.EscapeString(raw)
+	return strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(escaped)
+}
+
+// Фрагмент по поисковым ключам: первое поле, где ключ слова содержит ключ слова запроса,
+// около snippetRunes символов вокруг первого совпадения; совпавшие слова выделяются <mark>
+func keySnippet(fields []string, terms []searchTerm) string {
+	var needles []string
+	for _, t := range terms {
+		needles = append(needles, strings.Fields(models.SearchKey(t.Text))...)
+	}
+	for _, field := range fields {
+		segments := splitWords(field)
+		marked := make([]bool, len(segments))
+		first := -1
+		for i, segment := range segments {
+			key := models.SearchKey(segment)
+			for _, needle := range needles {
+				if strings.Contains(key, needle) {
+					marked[i] = true
+					break
+				}
+			}
+			if marked[i] && first < 0 {
+				first = i
+			}
+		}
+		if first < 0 {
+			continue
+		}
+
+		//границы фрагмента по словам: до совпадения около половины длины фрагмента
+		start, length := first, 0
+		for start > 0 && length < snippetRunes/2 {
+			start--
+			length += utf8.RuneCountInString(segments[start])
+		}
+		var b strings.Builder
+		if start > 0 {
+			b.WriteString("…")
+		}
+		end := start
+		for length = 0; end < len(segments) && (end <= first || length < snippetRunes); end++ {
+			length += utf8.RuneCountInString(segments[end])
+			if marked[end] {
+				b.WriteString("<mark>" + html.EscapeString(segments[end]) + "</mark>")
+			} else {
+				b.WriteString(html.EscapeString(segments[end]))
+			}
+		}
+		if end < len(segments) {
+			b.WriteString("…")
+		}
+		return b.String()
+	}
+	return ""
+}
+
+// разбиение текста на чередующиеся слова (буквы и цифры) и разделители
+func splitWords(text string) []string {
+	var segments []string
+	start, inWord := 0, false
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if i > 0 && isWord != inWord {
+			segments = append(segments, text[start:i])
+			start = i
+		}
+		inWord = isWord
+	}
+	if start < len(text) {
+		segments = append(segments, text[start:])
+	}
+	return segments
+}
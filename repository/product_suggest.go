@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"strings"
+	"unicode/utf8"
+)
+
+// минимальная длина слова словаря для исправления опечаток
+const minCorrectionRunes = 3
+
+// учет просмотра продукта для популярности в подсказках поиска
+func (r *ProductRepository) RecordView(id int) error {
+	_, err := r.DB.Exec(
+		"INSERT INTO product_views (product_id, views) VALUES (?, 1) ON CONFLICT (product_id) DO UPDATE SET views = views + 1", id)
+	return err
+}
+
+// Подсказки для строки поиска: продукты, производители и ингредиенты, у которых слово названия
+// начинается с prefix (по поисковому ключу); сначала названия, начинающиеся с prefix целиком,
+// затем по популярности - просмотрам продукта или числу продуктов производителя и ингредиента
+func (r *ProductRepository) Suggest(prefix string, limit int) ([]models.Suggestion, error) {
+	key := models.SearchKey(strings.TrimSpace(prefix))
+	if key == "" {
+		return nil, ErrEmptySearch
+	}
+	rows, err := r.DB.Query(`SELECT type, id, text, popularity FROM (
+			SELECT ?3 AS type, p.product_id AS id, p.product_title AS text, COALESCE(v.views, 0) AS popularity, 0 AS kind
+			FROM products p LEFT JOIN product_views v ON v.product_id = p.product_id
+			WHERE ' ' || search_key(p.product_title) LIKE ?1 ESCAPE '\'
+			UNION ALL
+			SELECT ?4, m.manufacturer_id, m.manufacturer_title, (SELECT COUNT(*) FROM products p WHERE p.manufacturer_id = m.manufacturer_id), 1
+			FROM manufacturer m WHERE ' ' || search_key(m.manufacturer_title) LIKE ?1 ESCAPE '\'
+			UNION ALL
+			SELECT ?5, s.structure_id, s.structure_name, (SELECT COUNT(*) FROM product_structure ps WHERE ps.structure_id = s.structure_id), 2
+			FROM structure s WHERE ' ' || search_key(s.structure_name) LIKE ?1 ESCAPE '\'
+		) ORDER BY search_key(text) LIKE ?2 ESCAPE '\' DESC, popularity DESC, kind, text COLLATE NOCASE LIMIT ?6`,
+		"% "+escapeLike(key)+"%", escapeLike(key)+"%", models.SuggestionProduct, models.SuggestionManufacturer, models.SuggestionStructure, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []models.Suggestion{}
+	for rows.Next() {
+		var s models.Suggestion
+		if err := rows.Scan(&s.Type, &s.ID, &s.Text, &s.Popularity); err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions, rows.Err()
+}
+
+// Поиск с исправлением опечаток: если по запросу ничего не найдено, поиск повторяется по исправленному
+// запросу; corrected - исправленный запрос («возможно, вы имели в виду»), если результаты найдены по нему
+func (r *ProductRepository) SearchCorrected(query string, filter ProductFilter, page Page) ([]models.ProductSearchResult, PageInfo, string, error) {
+	results, info, err := r.Search(query, filter, page)
+	if err != nil || info.Total > 0 {
+		return results, info, "", err
+	}
+	corrected, ok, err := r.CorrectSearch(query)
+	if err != nil || !ok {
+		return results, info, "", err
+	}
+	correctedResults, correctedInfo, err := r.Search(corrected, filter, page)
+	if err != nil || correctedInfo.Total == 0 {
+		return results, info, "", err
+	}
+	return correctedResults, correctedInfo, corrected, nil
+}
+
+// слово словаря каталога: наиболее частая форма написания и число вхождений
+type vocabularyWord struct {
+	Text  string
+	Count int
+}
+
+// Словарь каталога по поисковым ключам слов: названия, описания и применение продуктов,
+// производители и ингредиенты; собирается заново только после изменения каталога (catalog_version)
+func (r *ProductRepository) vocabulary() (map[string]*vocabularyWord, error) {
+	var version int64
+	if err := r.DB.QueryRow("SELECT version FROM catalog_version").Scan(&version); err != nil {
+		return nil, err
+	}
+	r.vocabularyMu.Lock()
+	defer r.vocabularyMu.Unlock()
+	if r.vocabularyCache != nil && r.vocabularyVersion == version {
+		return r.vocabularyCache, nil
+	}
+	vocabulary, err := r.buildVocabulary()
+	if err != nil {
+		return nil, err
+	}
+	r.vocabularyCache, r.vocabularyVersion = vocabulary, version
+	return vocabulary, nil
+}
+
+// сборка словаря каталога
+func (r *ProductRepository) buildVocabulary() (map[string]*vocabularyWord, error) {
+	rows, err := r.DB.Query(`SELECT product_title || ' ' || product_description || ' ' || application FROM products
+		UNION ALL SELECT manufacturer_title FROM manufacturer
+		UNION ALL SELECT structure_name FROM structure`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	forms := map[string]map[string]int{}
+	for rows.Next() {
+		var text string
+		if err := rows.Scan(&text); err != nil {
+			return nil, err
+		}
+		for _, word := range splitWords(text) {
+			if utf8.RuneCountInString(word) < minCorrectionRunes {
+				continue
+			}
+			key := models.SearchKey(word)
+			if forms[key] == nil {
+				forms[key] = map[string]int{}
+			}
+			forms[key][strings.ToLower(word)]++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	vocabulary := make(map[string]*vocabularyWord, len(forms))
+	for key, counts := range forms {
+		word := &vocabularyWord{}
+		best := 0
+		for form, count := range counts {
+			if count > best || count == best && form < word.Text {
+				word.Text, best = form, count
+			}
+			word.Count += count
+		}
+		vocabulary[key] = word
+	}
+	return vocabulary, nil
+}
+
+// Исправление опечаток в строке поиска: слова, которых нет в словаре каталога, заменяются
+// ближайшими по расстоянию Левенштейна между поисковыми ключами (при равенстве - более частыми)
+// Слова с поиском по началу (слово*) не исправляются; true, если исправлено хотя бы одно слово
+func (r *ProductRepository) CorrectSearch(query string) (string, bool, error) {
+	terms := parseSearch(query)
+	if len(terms) == 0 {
+		return "", false, nil
+	}
+	vocabulary, err := r.vocabulary()
+	if err != nil {
+		return "", false, err
+	}
+
+	changed := false
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		words := strings.Fields(t.Text)
+		if !t.Prefix {
+			for j, word := range words {
+				if fixed, ok := correctWord(word, vocabulary); ok {
+					words[j], changed = fixed, true
+				}
+			}
+		}
+		switch {
+		case len(words) > 1:
+			parts[i] = `"` + strings.Join(words, " ") + `"`
+		case t.Prefix:
+			parts[i] = words[0] + "*"
+		default:
+			parts[i] = words[0]
+		}
+	}
+	return strings.Join(parts, " "), changed, nil
+}
+
+// ближайшее к слову слово словаря; false, если слово есть в словаре или похожих нет
+func correctWord(word string, vocabulary map[string]*vocabularyWord) (string, bool) {
+	key := []rune(models.SearchKey(word))
+	if len(key) < minCorrectionRunes || vocabulary[string(key)] != nil {
+		return "", false
+	}
+	//допустимое число правок растет с длиной слова
+	maxDistance := 1
+	if len(key) > 5 {
+		maxDistance = 2
+	}
+	var best *vocabularyWord
+	bestDistance := maxDistance + 1
+	for candidate, entry := range vocabulary {
+		candidateRunes := []rune(candidate)
+		if abs(len(candidateRunes)-len(key)) > maxDistance {
+			continue
+		}
+		d := levenshtein(key, candidateRunes)
+		if d < bestDistance || d == bestDistance && best != nil && (entry.Count > best.Count || entry.Count == best.Count && entry.Text < best.Text) {
+			best, bestDistance = entry, d
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.Text, true
+}
+
+// расстояние Левенштейна между строками
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// генерация случайного секрета (код приглашения, токен) в URL-безопасном виде
+func newSecret(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// хеш секрета для хранения в БД; открытое значение в базе не сохраняется
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// время в UTC с точностью до секунды, чтобы строки DATETIME корректно сравнивались в SQL
+func dbTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
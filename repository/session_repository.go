@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ошибки обновления сессии
+var (
+	ErrRefreshInvalid = errors.New("токен обновления недействителен или истек")
+	ErrRefreshReused  = errors.New("повторное использование токена обновления, сессия отозвана")
+)
+
+// Сессия пользователя: цепочка ротируемых токенов обновления с общим идентификатором
+type SessionRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{DB: db}
+}
+
+// создание сессии и первого токена обновления; возвращает id сессии и открытый токен
+func (r *SessionRepository) Create(userID int, ttl time.Duration, ip, userAgent string) (string, string, error) {
+	sessionID, err := newSecret(16)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := newSecret(32)
+	if err != nil {
+		return "", "", err
+	}
+	now := dbTime(time.Now())
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"INSERT INTO sessions (id, user_id, created_at, expires_at, ip, user_agent) VALUES (?, ?, ?, ?, ?, ?)",
+		sessionID, userID, now, now.Add(ttl), ip, userAgent); err != nil {
+		return "", "", err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO refresh_tokens (session_id, token_hash, created_at) VALUES (?, ?, ?)",
+		sessionID, hashSecret(refreshToken), now); err != nil {
+		return "", "", err
+	}
+	return sessionID, refreshToken, tx.Commit()
+}
+
+// ротация токена обновления: старый погашается, выдается новый в той же сессии
+// Повторное предъявление погашенного токена означает его кражу - вся сессия отзывается
+func (r *SessionRepository) Rotate(refreshToken string, ttl time.Duration) (userID int, sessionID string, newToken string, err error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer tx.Rollback()
+
+	now := dbTime(time.Now())
+	var tokenID int
+	var usedAt sql.NullTime
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err = tx.QueryRow(`
+		SELECT rt.id, rt.used_at, s.id, s.user_id, s.expires_at, s.revoked_at
+		FROM refresh_tokens rt
+		JOIN sessions s ON s.id = rt.session_id
+		WHERE rt.token_hash = ?`, hashSecret(refreshToken)).Scan(&tokenID, &usedAt, &sessionID, &userID, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return 0, "", "", ErrRefreshInvalid
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+	if revokedAt.Valid || !expiresAt.After(now) {
+		return 0, "", "", ErrRefreshInvalid
+	}
+	if usedAt.Valid {
+		if _, err := tx.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ?", now, sessionID); err != nil {
+			return 0, "", "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, "", "", err
+		}
+		return 0, "", "", ErrRefreshReused
+	}
+
+	newToken, err = newSecret(32)
+	if err != nil {
+		return 0, "", "", err
+	}
+	//условие used_at IS NULL не дает погасить один токен двум параллельным запросам
+	result, err := tx.Exec("UPDATE refresh_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL", now, tokenID)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, "", "", ErrRefreshInvalid
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO refresh_tokens (session_id, token_hash, created_at) VALUES (?, ?, ?)",
+		sessionID, hashSecret(newToken), now); err != nil {
+		return 0, "", "", err
+	}
+	//скользящий срок жизни сессии
+	if _, err := tx.Exec("UPDATE sessions SET expires_at = ? WHERE id = ?", now.Add(ttl), sessionID); err != nil {
+		return 0, "", "", err
+	}
+	return userID, sessionID, newToken, tx.Commit()
+}
+
+// отзыв одной сессии (выход)
+func (r *SessionRepository) Revoke(sessionID string) error {
+	_, err := r.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", dbTime(time.Now()), sessionID)
+	return err
+}
+
+// отзыв всех сессий пользователя (выход на всех устройствах)
+func (r *SessionRepository) RevokeAllForUser(userID int) error {
+	_, err := r.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", dbTime(time.Now()), userID)
+	return err
+}
+
+// отзыв всех сессий пользователя, кроме текущей (например, после смены пароля)
+func (r *SessionRepository) RevokeAllForUserExcept(userID int, keepSessionID string) error {
+	_, err := r.DB.Exec("UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		dbTime(time.Now()), userID, keepSessionID)
+	return err
+}
+
+// внесение JWT в список отозванных до истечения его срока действия
+func (r *SessionRepository) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	now := dbTime(time.Now())
+	//записи об уже истекших токенах больше не нужны
+	if _, err := r.DB.Exec("DELETE FROM revoked_tokens WHERE expires_at < ?", now); err != nil {
+		return err
+	}
+	_, err := r.DB.Exec(
+		"INSERT OR IGNORE INTO revoked_tokens (jti, user_id, revoked_at, expires_at) VALUES (?, ?, ?, ?)",
+		jti, userID, now, dbTime(expiresAt))
+	return err
+}
+
+// проверка, отозван ли JWT сам по себе или вместе со своей сессией
+func (r *SessionRepository) IsRevoked(jti, sessionID string) (bool, error) {
+	var revoked bool
+	err := r.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ?)
+		    OR EXISTS (SELECT 1 FROM sessions WHERE id = ? AND revoked_at IS NOT NULL)`,
+		jti, sessionID).Scan(&revoked)
+	return revoked, err
+}
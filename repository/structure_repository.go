@@ -0,0 +1,557 @@
+package repository
+
+import (
+	"cosmetics/models"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ошибки изменения ингредиентов
+var (
+	ErrStructureExists   = errors.New("ингредиент с таким названием уже существует")
+	ErrStructureNotFound = errors.New("ингредиент не найден")
+)
+
+// Ингредиенты, у которых название, название INCI или синоним содержат образец LIKE (по поисковому ключу);
+// параметры - три одинаковых образца
+const structureNameSQL = `SELECT structure_id FROM structure WHERE search_key(structure_name) LIKE ? ESCAPE '\' OR search_key(inci_name) LIKE ? ESCAPE '\'
+	UNION SELECT structure_id FROM structure_synonym WHERE search_key(synonym) LIKE ? ESCAPE '\'`
+
+type StructureRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewStructureRepository(db *sql.DB) *StructureRepository {
+	return &StructureRepository{DB: db}
+}
+
+// запрос одной строки к БД или внутри транзакции
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Id ингредиента, которому соответствует name: по названию, названию INCI или синониму без учета
+// регистра, ё/е и транслитерации (по поисковому ключу), кроме exceptID; 0, если такого нет
+func lookupStructure(q rowQuerier, name string, exceptID int) (int, error) {
+	var id int
+	err := q.QueryRow(`SELECT structure_id FROM structure
+		WHERE structure_id <> ?2 AND (search_key(structure_name) = ?1 OR search_key(inci_name) = ?1)
+		UNION ALL SELECT structure_id FROM structure_synonym WHERE structure_id <> ?2 AND search_key(synonym) = ?1
+		LIMIT 1`, models.SearchKey(name), exceptID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// Приведение синонимов ингредиента: лишние пробелы убираются, код языка в нижнем регистре;
+// синонимы, совпадающие с названием, названием INCI или предыдущим синонимом, отбрасываются
+func cleanSynonyms(s *models.Structure) {
+	seen := map[string]bool{models.SearchKey(s.Name): true}
+	if s.INCIName != "" {
+		seen[models.SearchKey(s.INCIName)] = true
+	}
+	synonyms := []models.Synonym{}
+	for _, synonym := range s.Synonyms {
+		synonym.Name = strings.Join(strings.Fields(synonym.Name), " ")
+		synonym.Language = strings.ToLower(strings.TrimSpace(synonym.Language))
+		key := models.SearchKey(synonym.Name)
+		if synonym.Name == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		synonyms = append(synonyms, synonym)
+	}
+	s.Synonyms = synonyms
+}
+
+// Проверка, что название, название INCI и синонимы ингредиента не относятся к другому ингредиенту
+func checkStructureNames(q rowQuerier, s *models.Structure) error {
+	names := []string{s.Name}
+	if s.INCIName != "" {
+		names = append(names, s.INCIName)
+	}
+	for _, synonym := range s.Synonyms {
+		names = append(names, synonym.Name)
+	}
+	for _, name := range names {
+		id, err := lookupStructure(q, name, s.ID)
+		if err != nil {
+			return err
+		}
+		if id != 0 {
+			return fmt.Errorf("%w: «%s» (ингредиент %d)", ErrStructureExists, name, id)
+		}
+	}
+	return nil
+}
+
+// замена синонимов ингредиента внутри транзакции
+func replaceSynonyms(tx *sql.Tx, structureID int, synonyms []models.Synonym) error {
+	if _, err := tx.Exec("DELETE FROM structure_synonym WHERE structure_id = ?", structureID); err != nil {
+		return err
+	}
+	for _, synonym := range synonyms {
+		if _, err := tx.Exec("INSERT INTO structure_synonym (structure_id, synonym, language) VALUES (?, ?, ?)",
+			structureID, synonym.Name, synonym.Language); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// добавление нового ингредиента с синонимами
+func (r *StructureRepository) Create(structure *models.Structure) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	structure.ID = 0
+	cleanSynonyms(structure)
+	if err := checkStructureNames(tx, structure); err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		"INSERT INTO structure (structure_name, inci_name, cas_number, ec_number) VALUES (?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''))",
+		structure.Name, structure.INCIName, structure.CASNumber, structure.ECNumber)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	if err := replaceSynonyms(tx, int(id), structure.Synonyms); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	structure.ID = int(id)
+	return nil
+}
+
+// получение ингредиента по id с числом продуктов, синонимами и семействами; nil, если ингредиента нет
+func (r *StructureRepository) GetByID(id int) (*models.Structure, error) {
+	var s models.Structure
+	err := r.DB.QueryRow(
+		"SELECT s.structure_id, s.structure_name, (SELECT COUNT(*) FROM product_structure ps WHERE ps.structure_id = s.structure_id), "+
+			"COALESCE(s.inci_name, ''), COALESCE(s.cas_number, ''), COALESCE(s.ec_number, '') FROM structure s WHERE s.structure_id = ?",
+		id).Scan(&s.ID, &s.Name, &s.ProductCount, &s.INCIName, &s.CASNumber, &s.ECNumber)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Synonyms, err = r.synonyms(s.ID); err != nil {
+		return nil, err
+	}
+	if s.Families, err = NewFamilyRepository(r.DB).ForStructure(s.ID); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// синонимы ингредиента по языку и названию
+func (r *StructureRepository) synonyms(structureID int) ([]models.Synonym, error) {
+	rows, err := r.DB.Query("SELECT synonym, language FROM structure_synonym WHERE structure_id = ? ORDER BY language, synonym COLLATE NOCASE", structureID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var synonyms []models.Synonym
+	for rows.Next() {
+		var synonym models.Synonym
+		if err := rows.Scan(&synonym.Name, &synonym.Language); err != nil {
+			return nil, err
+		}
+		synonyms = append(synonyms, synonym)
+	}
+	return synonyms, rows.Err()
+}
+
+// Ингредиент, которому соответствует название, название INCI или синоним name; nil, если такого нет
+func (r *StructureRepository) Resolve(name string) (*models.Structure, error) {
+	id, err := lookupStructure(r.DB, name, 0)
+	if err != nil || id == 0 {
+		return nil, err
+	}
+	return r.GetByID(id)
+}
+
+// Получение всех ингредиентов с числом продуктов; query - поиск по части названия, названия INCI
+// или синонима (по поисковому ключу), family - только ингредиенты семейства (id или название) и всех его потомков
+func (r *StructureRepository) GetAll(query, family string) ([]models.Structure, error) {
+	sqlQuery := "SELECT s.structure_id, s.structure_name, COUNT(ps.product_id), " +
+		"COALESCE(s.inci_name, ''), COALESCE(s.cas_number, ''), COALESCE(s.ec_number, '') FROM structure s " +
+		"LEFT JOIN product_structure ps ON ps.structure_id = s.structure_id"
+	var where []string
+	var args []interface{}
+	if query != "" {
+		where = append(where, "s.structure_id IN ("+structureNameSQL+")")
+		pattern := likeContains(models.SearchKey(query))
+		args = append(args, pattern, pattern, pattern)
+	}
+	if family != "" {
+		cond, arg := familyCondition("s.structure_id", family)
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if len(where) > 0 {
+		sqlQuery += " WHERE " + strings.Join(where, " AND ")
+	}
+	sqlQuery += " GROUP BY s.structure_id ORDER BY s.structure_name COLLATE NOCASE"
+
+	rows, err := r.DB.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	structures := []models.Structure{}
+	for rows.Next() {
+		var s models.Structure
+		if err := rows.Scan(&s.ID, &s.Name, &s.ProductCount, &s.INCIName, &s.CASNumber, &s.ECNumber); err != nil {
+			return nil, err
+		}
+		structures = append(structures, s)
+	}
+	return structures, nil
+}
+
+// изменение названия, названия INCI, номеров и синонимов ингредиента
+func (r *StructureRepository) Update(structure *models.Structure) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cleanSynonyms(structure)
+	if err := checkStructureNames(tx, structure); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(
+		"UPDATE structure SET structure_name = ?, inci_name = NULLIF(?, ''), cas_number = NULLIF(?, ''), ec_number = NULLIF(?, '') WHERE structure_id = ?",
+		structure.Name, structure.INCIName, structure.CASNumber, structure.ECNumber, structure.ID); err != nil {
+		return err
+	}
+	if err := replaceSynonyms(tx, structure.ID, structure.Synonyms); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// удаление ингредиента вместе с его связями с продуктами
+func (r *StructureRepository) Delete(id int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := shiftPositions(tx, id, ""); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM product_structure WHERE structure_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM structure_family WHERE structure_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM structure_synonym WHERE structure_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM structure WHERE structure_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Объединение ингредиентов: продукты, семейства и синонимы ингредиентов sourceIDs переходят к targetID,
+// их названия и названия INCI становятся синонимами targetID, а недостающие у targetID название INCI
+// и номера CAS и EC берутся у объединяемых; сами ингредиенты sourceIDs удаляются
+func (r *StructureRepository) Merge(targetID int, sourceIDs []int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, sourceID := range sourceIDs {
+		var name, inci string
+		err := tx.QueryRow("SELECT structure_name, COALESCE(inci_name, '') FROM structure WHERE structure_id = ?", sourceID).Scan(&name, &inci)
+		if err != nil {
+			return err
+		}
+		//в продуктах, где уже есть targetID, ингредиент sourceID исключается, иначе targetID занимает его место
+		if err := shiftPositions(tx, sourceID, "product_id IN (SELECT product_id FROM product_structure WHERE structure_id = ?)", targetID); err != nil {
+			return err
+		}
+		statements := []string{
+			"INSERT OR IGNORE INTO product_structure (product_id, structure_id, position, concentration, below_one_percent, may_contain) " +
+				"SELECT product_id, ?1, position, concentration, below_one_percent, may_contain FROM product_structure WHERE structure_id = ?2",
+			"DELETE FROM product_structure WHERE structure_id = ?2",
+			"INSERT OR IGNORE INTO structure_family (structure_id, family_id) SELECT ?1, family_id FROM structure_family WHERE structure_id = ?2",
+			"DELETE FROM structure_family WHERE structure_id = ?2",
+			"UPDATE structure_synonym SET structure_id = ?1 WHERE structure_id = ?2",
+			`UPDATE structure SET
+				inci_name = COALESCE(inci_name, (SELECT inci_name FROM structure WHERE structure_id = ?2)),
+				cas_number = COALESCE(cas_number, (SELECT cas_number FROM structure WHERE structure_id = ?2)),
+				ec_number = COALESCE(ec_number, (SELECT ec_number FROM structure WHERE structure_id = ?2))
+			WHERE structure_id = ?1`,
+			"DELETE FROM structure WHERE structure_id = ?2",
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement, targetID, sourceID); err != nil {
+				return err
+			}
+		}
+		//названия удаленного ингредиента, которые еще не находят targetID, сохраняются синонимами
+		for _, synonym := range []string{name, inci} {
+			if synonym == "" {
+				continue
+			}
+			id, err := lookupStructure(tx, synonym, 0)
+			if err != nil {
+				return err
+			}
+			if id == 0 {
+				if _, err := tx.Exec("INSERT INTO structure_synonym (structure_id, synonym) VALUES (?, ?)", targetID, synonym); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// id из списка, которым не соответствует ни один ингредиент
+func (r *StructureRepository) Missing(ids []int) ([]int, error) {
+	var missing []int
+	for _, id := range ids {
+		var exists bool
+		if err := r.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM structure WHERE structure_id = ?)", id).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, id)
+		}
+	}
+	return missing, nil
+}
+
+// ингредиенты продукта в порядке этикетки с концентрациями
+func (r *StructureRepository) ForProduct(productID int) ([]models.Structure, error) {
+	rows, err := r.DB.Query(
+		"SELECT s.structure_id, s.structure_name, COALESCE(s.inci_name, ''), ps.position, ps.concentration, ps.below_one_percent, ps.may_contain "+
+			"FROM structure s JOIN product_structure ps ON ps.structure_id = s.structure_id WHERE ps.product_id = ? ORDER BY ps.position, ps.rowid",
+		productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	structures := []models.Structure{}
+	for rows.Next() {
+		var s models.Structure
+		var concentration sql.NullFloat64
+		if err := rows.Scan(&s.ID, &s.Name, &s.INCIName, &s.Position, &concentration, &s.BelowOnePercent, &s.MayContain); err != nil {
+			return nil, err
+		}
+		if concentration.Valid {
+			s.Concentration = &concentration.Float64
+		}
+		structures = append(structures, s)
+	}
+	return structures, rows.Err()
+}
+
+// замена всего состава продукта одной транзакцией
+func (r *StructureRepository) SetForProduct(productID int, structureIDs []int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM product_structure WHERE product_id = ?", productID); err != nil {
+		return err
+	}
+	if err := linkStructures(tx, productID, structureIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// добавление ингредиентов к составу продукта; уже входящие в состав пропускаются
+func (r *StructureRepository) AddToProduct(productID int, structureIDs []int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := linkStructures(tx, productID, structureIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// исключение ингредиента из состава продукта; false, если он не входил в состав
+func (r *StructureRepository) RemoveFromProduct(productID, structureID int) (bool, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := shiftPositions(tx, structureID, "product_id = ?", productID); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("DELETE FROM product_structure WHERE product_id = ? AND structure_id = ?", productID, structureID)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, tx.Commit()
+}
+
+// Сдвиг мест ингредиентов, следующих в составе за ингредиентом structureID, перед его исключением из состава
+// продуктов по условию products на product_id (пустое - из всех продуктов)
+func shiftPositions(tx *sql.Tx, structureID int, products string, args ...interface{}) error {
+	query := "UPDATE product_structure SET position = position - 1 WHERE position > " +
+		"(SELECT d.position FROM product_structure d WHERE d.product_id = product_structure.product_id AND d.structure_id = ?)"
+	if products != "" {
+		query += " AND " + products
+	}
+	_, err := tx.Exec(query, append([]interface{}{structureID}, args...)...)
+	return err
+}
+
+// вставка связей продукта с ингредиентами в конец состава внутри транзакции
+func linkStructures(tx *sql.Tx, productID int, structureIDs []int) error {
+	for _, id := range structureIDs {
+		_, err := tx.Exec("INSERT OR IGNORE INTO product_structure (product_id, structure_id, position) "+
+			"SELECT ?1, ?2, COALESCE(MAX(position), 0) + 1 FROM product_structure WHERE product_id = ?1", productID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// замена состава продукта внутри транзакции: ингредиент задается id или названием;
+// ингредиент с неизвестным названием создается, неизвестный id - ошибка ErrStructureNotFound
+func replaceStructures(tx *sql.Tx, productID int, structures []models.Structure) error {
+	for i := range structures {
+		id, err := resolveStructure(tx, structures[i])
+		if err != nil {
+			return err
+		}
+		structures[i].ID = id
+	}
+	if _, err := tx.Exec("DELETE FROM product_structure WHERE product_id = ?", productID); err != nil {
+		return err
+	}
+	//места на этикетке - по порядку списка; повторный ингредиент пропускается без пропуска места
+	position := 0
+	for _, s := range structures {
+		result, err := tx.Exec("INSERT OR IGNORE INTO product_structure (product_id, structure_id, position, concentration, below_one_percent, may_contain) "+
+			"VALUES (?, ?, ?, ?, ?, ?)", productID, s.ID, position+1, s.Concentration, s.BelowOnePercent, s.MayContain)
+		if err != nil {
+			return err
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			position++
+		}
+	}
+	return nil
+}
+
+// Id ингредиента по названию, а если по нему не найден - по одному из синонимов s; 0, если такого нет
+func findStructure(q rowQuerier, s models.Structure) (int, error) {
+	names := []string{s.Name}
+	for _, synonym := range s.Synonyms {
+		names = append(names, synonym.Name)
+	}
+	for _, name := range names {
+		id, err := lookupStructure(q, name, 0)
+		if err != nil || id != 0 {
+			return id, err
+		}
+	}
+	return 0, nil
+}
+
+// Id ингредиента по id или названию (в том числе INCI или синониму) с созданием нового по названию;
+// новый ингредиент получает синонимы s, которые еще не относятся к другим ингредиентам
+func resolveStructure(tx *sql.Tx, s models.Structure) (int, error) {
+	if s.ID > 0 {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM structure WHERE structure_id = ?)", s.ID).Scan(&exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			return 0, fmt.Errorf("%w: %d", ErrStructureNotFound, s.ID)
+		}
+		return s.ID, nil
+	}
+	s.Name = strings.Join(strings.Fields(s.Name), " ")
+	if s.Name == "" {
+		return 0, fmt.Errorf("%w: не указаны id или название", ErrStructureNotFound)
+	}
+	id, err := findStructure(tx, s)
+	if err != nil || id != 0 {
+		return id, err
+	}
+	result, err := tx.Exec("INSERT INTO structure (structure_name) VALUES (?)", s.Name)
+	if err != nil {
+		return 0, err
+	}
+	newID, _ := result.LastInsertId()
+	cleanSynonyms(&s)
+	for _, synonym := range s.Synonyms {
+		if _, err := tx.Exec("INSERT INTO structure_synonym (structure_id, synonym, language) VALUES (?, ?, ?)",
+			newID, synonym.Name, synonym.Language); err != nil {
+			return 0, err
+		}
+	}
+	return int(newID), nil
+}
+
+// Поиск ингредиентов, распознанных в строке состава, без изменения БД: заполняет StructureID и Known;
+// возвращает названия ингредиентов, которые будут созданы при записи состава
+func (r *StructureRepository) ResolveINCI(ingredients []models.INCIIngredient) ([]string, error) {
+	unknown := []string{}
+	for i := range ingredients {
+		id, err := findStructure(r.DB, ingredients[i].Structure())
+		if err != nil {
+			return nil, err
+		}
+		ingredients[i].StructureID, ingredients[i].Known = id, id != 0
+		if id == 0 {
+			unknown = append(unknown, ingredients[i].Name)
+		}
+	}
+	return unknown, nil
+}
+
+// Замена состава продукта ингредиентами по id или названию одной транзакцией;
+// structures получают id найденных и созданных ингредиентов
+func (r *StructureRepository) ReplaceForProduct(productID int, structures []models.Structure) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceStructures(tx, productID, structures); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
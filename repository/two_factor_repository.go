@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ошибка использования несуществующего, истекшего или исчерпанного токена второго шага входа
+var ErrChallengeInvalid = errors.New("сеанс подтверждения входа недействителен или истек, войдите заново")
+
+// число попыток ввода кода на один вход
+const maxChallengeAttempts = 5
+
+type TwoFactorRepository struct {
+	DB *sql.DB
+}
+
+// конструктор с подключением
+func NewTwoFactorRepository(db *sql.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{DB: db}
+}
+
+// секрет TOTP пользователя и номер последнего принятого временного шага
+func (r *TwoFactorRepository) GetSecret(userID int) (string, int64, error) {
+	var secret sql.NullString
+	var lastStep int64
+	err := r.DB.QueryRow("SELECT totp_secret, totp_last_step FROM users WHERE id = ?", userID).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return "", 0, nil
+	}
+	return secret.String, lastStep, err
+}
+
+// сохранение секрета на время подключения; у пользователя с включенной 2FA секрет не меняется
+func (r *TwoFactorRepository) SetPendingSecret(userID int, secret string) (bool, error) {
+	result, err := r.DB.Exec("UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled = 0", secret, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// включение 2FA с первым принятым кодом и новым набором кодов восстановления
+func (r *TwoFactorRepository) Enable(userID int, step int64, recoveryCodes []string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// отключение 2FA: секрет, коды восстановления и незавершенные входы удаляются
+func (r *TwoFactorRepository) Disable(userID int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM mfa_challenges WHERE user_id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// фиксация принятого временного шага; повторное использование того же кода отклоняется
+func (r *TwoFactorRepository) MarkStep(userID int, step int64) (bool, error) {
+	result, err := r.DB.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// замена кодов восстановления новым набором
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID int, codes []string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// коды восстановления хранятся только в виде хешей
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashSecret(code)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// погашение кода восстановления; false, если код неверный или уже использован
+func (r *TwoFactorRepository) UseRecoveryCode(userID int, code string) (bool, error) {
+	result, err := r.DB.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE id = (SELECT id FROM recovery_codes WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1)",
+		dbTime(time.Now()), userID, hashSecret(code))
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// число неиспользованных кодов восстановления
+func (r *TwoFactorRepository) RemainingRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// создание токена второго шага входа после проверки пароля
+func (r *TwoFactorRepository) CreateChallenge(userID int, ttl time.Duration) (string, error) {
+	token, err := newSecret(32)
+	if err != nil {
+		return "", err
+	}
+	now := dbTime(time.Now())
+	_, err = r.DB.Exec(
+		"INSERT INTO mfa_challenges (user_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		userID, hashSecret(token), now, now.Add(ttl))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// id пользователя действующего токена второго шага
+func (r *TwoFactorRepository) GetChallenge(token string) (int, error) {
+	var userID int
+	err := r.DB.QueryRow(
+		"SELECT user_id FROM mfa_challenges WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?",
+		hashSecret(token), dbTime(time.Now()), maxChallengeAttempts).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrChallengeInvalid
+	}
+	return userID, err
+}
+
+// учет неверного кода; после maxChallengeAttempts попыток токен перестает действовать
+func (r *TwoFactorRepository) FailChallenge(token string) error {
+	_, err := r.DB.Exec("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = ?", hashSecret(token))
+	return err
+}
+
+// погашение токена второго шага после успешной проверки кода
+func (r *TwoFactorRepository) CompleteChallenge(token string) error {
+	result, err := r.DB.Exec(
+		"UPDATE mfa_challenges SET used_at = ? WHERE token_hash = ? AND used_at IS NULL",
+		dbTime(time.Now()), hashSecret(token))
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrChallengeInvalid
+	}
+	return nil
+}
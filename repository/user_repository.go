@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"time"
 )
 
 type UserRepository struct {
@@ -21,9 +22,13 @@ func (r *UserRepository) CreateUser(user *models.User) error {
 	if r.DB == nil {
 		return fmt.Errorf("database connection is nil")
 	}
-	query := "INSERT INTO users (username, password) VALUES (?, ?)"
+	//роль по умолчанию - только чтение
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+	query := "INSERT INTO users (username, password, role) VALUES (?, ?, ?)"
 
-	result, err := r.DB.Exec(query, user.UserName, user.Password)
+	result, err := r.DB.Exec(query, user.UserName, user.Password, user.Role)
 	if err != nil {
 		log.Printf("DB Error (CreateUser): %v", err)
 		return fmt.Errorf("ошибка при создании пользователя: %w", err)
@@ -33,23 +38,40 @@ func (r *UserRepository) CreateUser(user *models.User) error {
 	if rowsAffected == 0 {
 		return fmt.Errorf("пользователь не был создан")
 	}
+	id, _ := result.LastInsertId()
+	user.ID = int(id)
 
 	fmt.Printf("--- Пользователь '%s' успешно сохранен в БД ---\n", user.UserName)
 	return nil
 }
 
+// столбцы пользователя в порядке сканирования scanUser
+const userColumns = "id, username, password, role, failed_logins, locked_until, disabled, totp_enabled"
+
+// сканирование строки результата в структуру пользователя
+func scanUser(row interface{ Scan(...interface{}) error }) (*models.User, error) {
+	user := &models.User{}
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&user.ID, &user.UserName, &user.Password, &user.Role, &user.FailedLogins, &lockedUntil, &user.Disabled, &user.TwoFactorEnabled); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		user.LockedUntil = &lockedUntil.Time
+	}
+	return user, nil
+}
+
 // получение пользователя по логину
 func (r *UserRepository) GetUserByUsername(username string) (*models.User, error) {
 	if r.DB == nil {
 		return nil, fmt.Errorf("database connection is nil")
 	}
 
-	user := &models.User{}
-	query := "SELECT id, username, password FROM users WHERE username = ?"
+	query := "SELECT " + userColumns + " FROM users WHERE username = ?"
 
 	row := r.DB.QueryRow(query, username)
 
-	err := row.Scan(&user.ID, &user.UserName, &user.Password)
+	user, err := scanUser(row)
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -61,3 +83,140 @@ func (r *UserRepository) GetUserByUsername(username string) (*models.User, error
 
 	return user, nil
 }
+
+// получение пользователя по id
+func (r *UserRepository) GetUserByID(id int) (*models.User, error) {
+	if r.DB == nil {
+		return nil, fmt.Errorf("database connection is nil")
+	}
+
+	user, err := scanUser(r.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("DB Error (GetUserByID): %v", err)
+		return nil, fmt.Errorf("ошибка при получении пользователя: %w", err)
+	}
+	return user, nil
+}
+
+// изменение роли пользователя
+func (r *UserRepository) UpdateRole(id int, role models.Role) error {
+	_, err := r.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, id)
+	if err != nil {
+		log.Printf("DB Error (UpdateRole): %v", err)
+		return fmt.Errorf("ошибка при изменении роли пользователя: %w", err)
+	}
+	return nil
+}
+
+// Учет неудачной попытки входа; при достижении maxFailures учетная запись блокируется до now+lockout
+// После истечения блокировки счет начинается заново, иначе одна неудача сразу блокировала бы снова
+func (r *UserRepository) RegisterFailedLogin(id int, maxFailures int, lockout time.Duration) error {
+	now := time.Now()
+	_, err := r.DB.Exec(`
+		UPDATE users SET
+			failed_logins = CASE WHEN locked_until IS NOT NULL AND locked_until <= ?1 THEN 1 ELSE failed_logins + 1 END,
+			locked_until = CASE
+				WHEN locked_until IS NOT NULL AND locked_until <= ?1 THEN CASE WHEN 1 >= ?2 THEN ?3 ELSE NULL END
+				WHEN failed_logins + 1 >= ?2 THEN ?3
+				ELSE locked_until END
+		WHERE id = ?4`, dbTime(now), maxFailures, dbTime(now.Add(lockout)), id)
+	if err != nil {
+		log.Printf("DB Error (RegisterFailedLogin): %v", err)
+		return fmt.Errorf("ошибка при учете попытки входа: %w", err)
+	}
+	return nil
+}
+
+// сброс счетчика неудачных попыток и снятие блокировки (успешный вход или разблокировка администратором)
+func (r *UserRepository) ResetLoginFailures(id int) error {
+	_, err := r.DB.Exec("UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?", id)
+	if err != nil {
+		log.Printf("DB Error (ResetLoginFailures): %v", err)
+		return fmt.Errorf("ошибка при разблокировке пользователя: %w", err)
+	}
+	return nil
+}
+
+// обновление хеша пароля
+func (r *UserRepository) UpdatePassword(id int, passwordHash string) error {
+	_, err := r.DB.Exec("UPDATE users SET password = ? WHERE id = ?", passwordHash, id)
+	if err != nil {
+		log.Printf("DB Error (UpdatePassword): %v", err)
+		return fmt.Errorf("ошибка при изменении пароля: %w", err)
+	}
+	return nil
+}
+
+// получение списка пользователей с поиском по части логина
+func (r *UserRepository) GetAll(search string) ([]models.User, error) {
+	query := "SELECT " + userColumns + " FROM users"
+	var args []interface{}
+	if search != "" {
+		query += " WHERE username LIKE ? ESCAPE '\\'"
+		args = append(args, likeContains(search))
+	}
+	query += " ORDER BY id"
+
+	rows, err := r.DB.Query(query, args...)
+	if err != nil {
+		log.Printf("DB Error (GetAll users): %v", err)
+		return nil, fmt.Errorf("ошибка при получении пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	return users, nil
+}
+
+// отключение или включение учетной записи
+func (r *UserRepository) SetDisabled(id int, disabled bool) error {
+	_, err := r.DB.Exec("UPDATE users SET disabled = ? WHERE id = ?", disabled, id)
+	if err != nil {
+		log.Printf("DB Error (SetDisabled): %v", err)
+		return fmt.Errorf("ошибка при изменении состояния пользователя: %w", err)
+	}
+	return nil
+}
+
+// число активных администраторов, не считая пользователя exceptID
+func (r *UserRepository) CountActiveAdmins(exceptID int) (int, error) {
+	var count int
+	err := r.DB.QueryRow("SELECT COUNT(*) FROM users WHERE role = ? AND disabled = 0 AND id <> ?", models.RoleAdmin, exceptID).Scan(&count)
+	return count, err
+}
+
+// удаление пользователя вместе с его сессиями и токенами
+func (r *UserRepository) Delete(id int) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		"DELETE FROM refresh_tokens WHERE session_id IN (SELECT id FROM sessions WHERE user_id = ?)",
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM password_resets WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM mfa_challenges WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, id); err != nil {
+			log.Printf("DB Error (Delete user): %v", err)
+			return fmt.Errorf("ошибка при удалении пользователя: %w", err)
+		}
+	}
+	return tx.Commit()
+}